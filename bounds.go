@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultMargin = 20
+
+	// textWidthFactor approximates the width of a character in the fonts used on the map, relative to the font size
+	textWidthFactor = 0.6
+)
+
+// errSharedLayout is returned when rewriting the layout of a map file would break the maps built on it
+var errSharedLayout = errors.New("layout is shared with other maps")
+
+type bbox struct {
+	MinX, MinY, MaxX, MaxY int32
+	empty                  bool
+}
+
+func (b *bbox) add(x1, y1, x2, y2 int32) {
+	if b.empty {
+		b.MinX, b.MinY, b.MaxX, b.MaxY = x1, y1, x2, y2
+		b.empty = false
+		return
+	}
+	if x1 < b.MinX {
+		b.MinX = x1
+	}
+	if y1 < b.MinY {
+		b.MinY = y1
+	}
+	if x2 > b.MaxX {
+		b.MaxX = x2
+	}
+	if y2 > b.MaxY {
+		b.MaxY = y2
+	}
+}
+
+func textWidth(text string, size int32) int32 {
+	return int32(float64(len([]rune(text))*int(size)) * textWidthFactor)
+}
+
+// Bounds returns the box that every system, label and annotation of mp is drawn in
+func (mp spyglassMap) Bounds() (bbox, error) {
+	b := bbox{empty: true}
+
+	for _, s := range mp.Systems {
+		st, err := mp.SystemStyle(s)
+		if err != nil {
+			return b, err
+		}
+		b.add(s.X, s.Y, s.X+st.Width, s.Y+st.Height)
+
+		// Long names spill out of the sides of their box
+		name := s.Name
+		if st.Label != "" {
+			name = st.Label
+		}
+		cx, _ := st.Center(s)
+		if w := textWidth(name, st.FontSize); w > st.Width {
+			b.add(cx-w/2, s.Y, cx+w/2, s.Y+st.Height)
+		}
+	}
+
+	for _, a := range mp.Annotations {
+		size := a.Size
+		if size == 0 {
+			size = 10
+		}
+		// Annotations are placed by the start of their baseline
+		b.add(a.X, a.Y-size, a.X+textWidth(a.Text, size), a.Y)
+	}
+
+	return b, nil
+}
+
+// Fit moves the content of mp to sit margin away from the origin, snaps every position to a multiple of grid
+// and sizes the canvas to hold it all with the same margin. A grid of 0 or 1 leaves positions unsnapped.
+func (mp *spyglassMap) Fit(margin, grid int32) error {
+	b, err := mp.Bounds()
+	if err != nil {
+		return err
+	}
+	if b.empty {
+		mp.Width, mp.Height = 2*margin, 2*margin
+		return nil
+	}
+
+	dx, dy := margin-b.MinX, margin-b.MinY
+
+	for id, s := range mp.Systems {
+		s.X = snap(s.X+dx, grid)
+		s.Y = snap(s.Y+dy, grid)
+		mp.Systems[id] = s
+	}
+	for i, a := range mp.Annotations {
+		a.X = snap(a.X+dx, grid)
+		a.Y = snap(a.Y+dy, grid)
+		mp.Annotations[i] = a
+	}
+
+	// Snapping can push content out by up to half a grid step so measure again
+	b, err = mp.Bounds()
+	if err != nil {
+		return err
+	}
+	mp.Width = snapUp(b.MaxX+margin, grid)
+	mp.Height = snapUp(b.MaxY+margin, grid)
+
+	return nil
+}
+
+func snap(v, grid int32) int32 {
+	if grid <= 1 {
+		return v
+	}
+	if v < 0 {
+		return -snap(-v, grid)
+	}
+	return (v + grid/2) / grid * grid
+}
+
+func snapUp(v, grid int32) int32 {
+	if grid <= 1 {
+		return v
+	}
+	if v < 0 {
+		return -(-v / grid * grid)
+	}
+	return (v + grid - 1) / grid * grid
+}
+
+// FitMapFile fits the map file at p and, when write is set, stores the new positions and size back in it
+func (em *EveMapper) FitMapFile(p string, margin, grid int32, write bool) (spyglassMap, error) {
+	m, err := em.readLayout(p)
+	if err != nil {
+		return m, err
+	}
+	err = checkPlainLayout(p, m)
+	if err != nil {
+		return m, err
+	}
+
+	err = m.Fit(margin, grid)
+	if err != nil || !write {
+		return m, err
+	}
+
+	return m, writeLayout(p, m)
+}
+
+// readLayout decodes the map file at p as it is on disk, without resolving its includes or overlays
+func (em *EveMapper) readLayout(p string) (spyglassMap, error) {
+	m, err := readRawMap(p)
+	if err != nil {
+		return m, err
+	}
+
+	// Names are only needed to measure the labels, the file keeps whatever it had
+	em.resolveNames(&m)
+
+	return m, nil
+}
+
+// checkPlainLayout refuses map files whose layout cannot be rewritten on its own. Positions of a composite map
+// only make sense once its includes are merged, which also grow its canvas as long as it has no size, and
+// overlays move the systems of their base map to absolute positions that would no longer fit.
+func checkPlainLayout(p string, m spyglassMap) error {
+	if len(m.Includes) > 0 {
+		return fmt.Errorf("'%s' includes other maps: %w", filepath.Base(p), errSharedLayout)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	overlays, err := listMapFiles(overlaysDir)
+	if err != nil {
+		return err
+	}
+	for _, o := range overlays {
+		ov, err := readOverlayFile(o)
+		if err != nil {
+			return err
+		}
+		if ov.Base == name {
+			return fmt.Errorf("'%s' is the base of overlay '%s': %w", filepath.Base(p), o, errSharedLayout)
+		}
+	}
+	return nil
+}
+
+// writeLayout stores the size of m and the positions of its systems and annotations in the map file at p,
+// touching nothing else so that its format, key order and comments are kept
+func writeLayout(p string, m spyglassMap) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(p)
+	doc, err := parseMapDocument(data, ext)
+	if err != nil {
+		return err
+	}
+	if doc.Kind != yaml.MappingNode {
+		return fmt.Errorf("'%s' is not a map file", p)
+	}
+
+	if m.Width != 0 && m.Height != 0 {
+		setNodeInt(doc, "width", m.Width)
+		setNodeInt(doc, "height", m.Height)
+	}
+
+	if systems := nodeValue(doc, "systems"); systems != nil && systems.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(systems.Content); i += 2 {
+			id, err := strconv.Atoi(systems.Content[i].Value)
+			if err != nil {
+				continue
+			}
+			s, ok := m.Systems[int32(id)]
+			if !ok {
+				continue
+			}
+			sys := resolveAlias(systems.Content[i+1])
+			setNodeInt(sys, "x", s.X)
+			setNodeInt(sys, "y", s.Y)
+		}
+	}
+
+	if annotations := nodeValue(doc, "annotations"); annotations != nil && annotations.Kind == yaml.SequenceNode {
+		for i, n := range annotations.Content {
+			if i >= len(m.Annotations) {
+				break
+			}
+			n = resolveAlias(n)
+			setNodeInt(n, "x", m.Annotations[i].X)
+			setNodeInt(n, "y", m.Annotations[i].Y)
+		}
+	}
+
+	out, err := writeMapDocument(doc, ext)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, out, 0644)
+}
+
+// nodeValue returns the value stored under key in a mapping node
+func nodeValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return resolveAlias(n.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// setNodeInt stores v under key in a mapping node, adding the key when it is missing
+func setNodeInt(n *yaml.Node, key string, v int32) {
+	val := strconv.Itoa(int(v))
+	if existing := nodeValue(n, key); existing != nil {
+		existing.Kind = yaml.ScalarNode
+		existing.Tag = "!!int"
+		existing.Value = val
+		existing.Content = nil
+		return
+	}
+	n.Content = append(n.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: val})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	type pos [2]int32
+	tests := []struct {
+		name          string
+		mp            spyglassMap
+		margin, grid  int32
+		systems       map[int32]pos
+		annotations   []pos
+		width, height int32
+	}{
+		{
+			name:   "empty map",
+			mp:     spyglassMap{Width: 500, Height: 300},
+			margin: 20,
+			grid:   10,
+			width:  40,
+			height: 40,
+		},
+		{
+			name:    "one system",
+			mp:      spyglassMap{Systems: map[int32]spyglassSystem{1: {ID: 1, Name: "Alpha", X: 137, Y: 243}}},
+			margin:  20,
+			systems: map[int32]pos{1: {20, 20}},
+			width:   90,
+			height:  62,
+		},
+		{
+			name:    "one system on a grid",
+			mp:      spyglassMap{Systems: map[int32]spyglassSystem{1: {ID: 1, Name: "Alpha", X: 137, Y: 243}}},
+			margin:  20,
+			grid:    10,
+			systems: map[int32]pos{1: {20, 20}},
+			width:   90,
+			height:  70,
+		},
+		{
+			name:    "system at negative positions",
+			mp:      spyglassMap{Systems: map[int32]spyglassSystem{1: {ID: 1, Name: "Alpha", X: -37, Y: -3}}},
+			margin:  5,
+			systems: map[int32]pos{1: {5, 5}},
+			width:   60,
+			height:  32,
+		},
+		{
+			name: "systems and an annotation",
+			mp: spyglassMap{
+				Systems: map[int32]spyglassSystem{
+					1: {ID: 1, Name: "Alpha", X: 100, Y: 100},
+					2: {ID: 2, Name: "Bravo", X: 300, Y: 50},
+				},
+				Annotations: []spyglassAnnotation{{Text: "north", X: 90, Y: 60}},
+			},
+			margin:      20,
+			systems:     map[int32]pos{1: {30, 70}, 2: {230, 20}},
+			annotations: []pos{{20, 30}},
+			width:       300,
+			height:      112,
+		},
+		{
+			name:    "long name spilling out of its box",
+			mp:      spyglassMap{Systems: map[int32]spyglassSystem{1: {ID: 1, Name: "A very long system name", X: 100, Y: 100}}},
+			margin:  20,
+			systems: map[int32]pos{1: {57, 20}},
+			width:   164,
+			height:  62,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := tt.mp
+			err := mp.Fit(tt.margin, tt.grid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mp.Width != tt.width || mp.Height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", mp.Width, mp.Height, tt.width, tt.height)
+			}
+			for id, want := range tt.systems {
+				s := mp.Systems[id]
+				if got := (pos{s.X, s.Y}); got != want {
+					t.Errorf("system %d at %v, want %v", id, got, want)
+				}
+			}
+			var annotations []pos
+			for _, a := range mp.Annotations {
+				annotations = append(annotations, pos{a.X, a.Y})
+			}
+			if !reflect.DeepEqual(annotations, tt.annotations) {
+				t.Errorf("annotations at %v, want %v", annotations, tt.annotations)
+			}
+		})
+	}
+}
+
+func TestSnap(t *testing.T) {
+	tests := []struct {
+		v, grid  int32
+		snap, up int32
+	}{
+		{14, 10, 10, 20},
+		{15, 10, 20, 20},
+		{20, 10, 20, 20},
+		{-14, 10, -10, -10},
+		{-15, 10, -20, -10},
+		{17, 0, 17, 17},
+		{17, 1, 17, 17},
+	}
+
+	for _, tt := range tests {
+		if got := snap(tt.v, tt.grid); got != tt.snap {
+			t.Errorf("snap(%d, %d) = %d, want %d", tt.v, tt.grid, got, tt.snap)
+		}
+		if got := snapUp(tt.v, tt.grid); got != tt.up {
+			t.Errorf("snapUp(%d, %d) = %d, want %d", tt.v, tt.grid, got, tt.up)
+		}
+	}
+}
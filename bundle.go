@@ -0,0 +1,300 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	iconsDir = "./icons"
+
+	bundleManifestFile = "manifest.json"
+	bundleFormat       = 1
+)
+
+type (
+	// bundleManifest describes the contents of a map bundle, a zip archive that Spyglass 2 clients can install maps from.
+	// Maps are stored flattened, so the includes and overlays of the server are already applied.
+	bundleManifest struct {
+		Format  int          `json:"format"`
+		Created time.Time    `json:"created"`
+		Maps    []bundleMap  `json:"maps"`
+		Icons   []bundleFile `json:"icons,omitempty"`
+	}
+
+	bundleMap struct {
+		bundleFile
+		catalogEntry
+	}
+
+	bundleFile struct {
+		File   string `json:"file"`
+		SHA256 string `json:"sha256"`
+	}
+
+	// bundleReport is the outcome of importing a bundle, files named in Conflicts differ from the installed ones
+	// and were left alone, files named in Failed did not match their checksum
+	bundleReport struct {
+		Installed []string `json:"installed,omitempty"`
+		Unchanged []string `json:"unchanged,omitempty"`
+		Conflicts []string `json:"conflicts,omitempty"`
+		Failed    []string `json:"failed,omitempty"`
+	}
+)
+
+// WriteBundle writes a bundle holding the named maps and the icons they use to w
+func (em *EveMapper) WriteBundle(w io.Writer, names []string) error {
+	manifest := bundleManifest{
+		Format:  bundleFormat,
+		Created: time.Now().UTC(),
+	}
+
+	zw := zip.NewWriter(w)
+
+	icons := make(map[string]bool)
+
+	for _, name := range names {
+		m, err := em.LoadMap(name)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(m, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		file := path.Join("maps", name+".json")
+		err = writeBundleFile(zw, file, data)
+		if err != nil {
+			return err
+		}
+
+		manifest.Maps = append(manifest.Maps, bundleMap{
+			bundleFile:   bundleFile{File: file, SHA256: checksum(data)},
+			catalogEntry: em.describeMap(name, m),
+		})
+
+		for _, s := range m.Systems {
+			if s.Icon != "" {
+				icons[s.Icon] = true
+			}
+		}
+	}
+
+	iconNames := make([]string, 0, len(icons))
+	for icon := range icons {
+		iconNames = append(iconNames, icon)
+	}
+	sort.Strings(iconNames)
+
+	for _, icon := range iconNames {
+		if strings.Contains(icon, "..") || filepath.IsAbs(icon) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(iconsDir, filepath.FromSlash(icon)))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Icons can also be urls or names built into the client
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		file := path.Join("icons", icon)
+		err = writeBundleFile(zw, file, data)
+		if err != nil {
+			return err
+		}
+		manifest.Icons = append(manifest.Icons, bundleFile{File: file, SHA256: checksum(data)})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = writeBundleFile(zw, bundleManifestFile, data)
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// ImportBundle installs the maps and icons of the bundle at p. Files whose checksum does not match are rejected,
+// installed files that differ from the bundled ones are only replaced when force is set.
+func ImportBundle(p string, force bool) (bundleReport, error) {
+	var report bundleReport
+
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return report, err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	mf, ok := files[bundleManifestFile]
+	if !ok {
+		return report, fmt.Errorf("bundle '%s' has no %s", p, bundleManifestFile)
+	}
+	raw, err := readZipFile(mf)
+	if err != nil {
+		return report, err
+	}
+
+	var manifest bundleManifest
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return report, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Format > bundleFormat {
+		return report, fmt.Errorf("bundle format %d is newer than this mapper supports (%d)", manifest.Format, bundleFormat)
+	}
+
+	entries := make([]bundleFile, 0, len(manifest.Maps)+len(manifest.Icons))
+	for _, m := range manifest.Maps {
+		entries = append(entries, m.bundleFile)
+	}
+	entries = append(entries, manifest.Icons...)
+
+	for _, e := range entries {
+		dest, err := bundleDestination(e.File)
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %s", e.File, err.Error()))
+			continue
+		}
+
+		f, ok := files[e.File]
+		if !ok {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: missing from the bundle", e.File))
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			return report, err
+		}
+		if checksum(data) != e.SHA256 {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: checksum mismatch", e.File))
+			continue
+		}
+
+		existing, err := installedFile(dest)
+		if err != nil {
+			return report, err
+		}
+		if existing != "" {
+			current, err := os.ReadFile(existing)
+			if err != nil {
+				return report, err
+			}
+			if filepath.Ext(existing) == filepath.Ext(dest) && bytes.Equal(current, data) {
+				report.Unchanged = append(report.Unchanged, e.File)
+				continue
+			}
+			if !force {
+				report.Conflicts = append(report.Conflicts, fmt.Sprintf("%s: differs from installed %s", e.File, existing))
+				continue
+			}
+		}
+
+		err = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
+		if err != nil {
+			return report, err
+		}
+		err = os.WriteFile(dest, data, 0644)
+		if err != nil {
+			return report, err
+		}
+		report.Installed = append(report.Installed, e.File)
+	}
+
+	return report, nil
+}
+
+// bundleDestination maps a file in the bundle to where it is installed
+func bundleDestination(file string) (string, error) {
+	clean := path.Clean(file)
+	if clean != file || strings.HasPrefix(clean, "../") || path.IsAbs(clean) || strings.Contains(file, `\`) {
+		return "", errors.New("invalid file name")
+	}
+
+	dir, name := path.Split(clean)
+	switch dir {
+	case "maps/":
+		if path.Ext(name) != ".json" {
+			return "", errors.New("maps must be json")
+		}
+		return filepath.Join(mapsDir, name), nil
+	case "icons/":
+		return filepath.Join(iconsDir, name), nil
+	}
+	if strings.HasPrefix(dir, "icons/") {
+		return filepath.Join(iconsDir, filepath.FromSlash(strings.TrimPrefix(clean, "icons/"))), nil
+	}
+	return "", errors.New("unexpected location in bundle")
+}
+
+// installedFile returns the absolute path of the file already installed at dest,
+// a map counts as installed in any of the supported formats
+func installedFile(dest string) (string, error) {
+	if filepath.Dir(dest) == filepath.Clean(mapsDir) {
+		p, err := findMapFile(mapsDir, strings.TrimSuffix(filepath.Base(dest), ".json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return p, err
+	}
+
+	p, err := filepath.Abs(dest)
+	if err != nil {
+		return "", err
+	}
+	_, err = os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func writeBundleFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const bundleMapJSON = `{"name": "Test", "systems": {"1": {"name": "Alpha", "x": 10, "y": 10}}}`
+
+// bundleEntry is a file of a test bundle. The manifest lists it with the checksum of sum, or of data when sum is empty,
+// and unlisted entries are only put in the zip.
+type bundleEntry struct {
+	file     string
+	data     string
+	sum      string
+	icon     bool
+	unlisted bool
+	missing  bool
+}
+
+// writeTestBundle writes a bundle of the given entries to p
+func writeTestBundle(t *testing.T, p string, entries []bundleEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	manifest := bundleManifest{Format: bundleFormat}
+	for _, e := range entries {
+		if !e.missing {
+			err := writeBundleFile(zw, e.file, []byte(e.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if e.unlisted {
+			continue
+		}
+		sum := e.sum
+		if sum == "" {
+			sum = checksum([]byte(e.data))
+		}
+		f := bundleFile{File: e.file, SHA256: sum}
+		if e.icon {
+			manifest.Icons = append(manifest.Icons, f)
+		} else {
+			manifest.Maps = append(manifest.Maps, bundleMap{bundleFile: f})
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeBundleFile(zw, bundleManifestFile, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(p, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed map[string]string
+		entries   []bundleEntry
+		force     bool
+		report    bundleReport
+		conflicts []string
+		files     map[string]string
+	}{
+		{
+			name:    "map and icon",
+			entries: []bundleEntry{{file: "maps/Test.json", data: bundleMapJSON}, {file: "icons/ships/hub.png", data: "png", icon: true}},
+			report:  bundleReport{Installed: []string{"maps/Test.json", "icons/ships/hub.png"}},
+			files:   map[string]string{"maps/Test.json": bundleMapJSON, "icons/ships/hub.png": "png"},
+		},
+		{
+			name:    "checksum mismatch",
+			entries: []bundleEntry{{file: "maps/Test.json", data: bundleMapJSON, sum: checksum([]byte("something else"))}},
+			report:  bundleReport{Failed: []string{"maps/Test.json: checksum mismatch"}},
+		},
+		{
+			name:    "listed but missing",
+			entries: []bundleEntry{{file: "maps/Test.json", data: bundleMapJSON, missing: true}},
+			report:  bundleReport{Failed: []string{"maps/Test.json: missing from the bundle"}},
+		},
+		{
+			name: "names leading outside",
+			entries: []bundleEntry{
+				{file: "../evil.json", data: "x"},
+				{file: "maps/../../evil.json", data: "x"},
+				{file: "icons/../../evil.png", data: "x", icon: true},
+				{file: "/tmp/evil.png", data: "x", icon: true},
+				{file: `icons/..\..\evil.png`, data: "x", icon: true},
+				{file: "..", data: "x"},
+				{file: "evil.json", data: "x"},
+				{file: "maps/evil.yaml", data: "x"},
+			},
+			report: bundleReport{Failed: []string{
+				"../evil.json: invalid file name",
+				"maps/../../evil.json: invalid file name",
+				"..: unexpected location in bundle",
+				"evil.json: unexpected location in bundle",
+				"maps/evil.yaml: maps must be json",
+				"icons/../../evil.png: invalid file name",
+				"/tmp/evil.png: invalid file name",
+				`icons/..\..\evil.png: invalid file name`,
+			}},
+		},
+		{
+			name:    "unlisted files are ignored",
+			entries: []bundleEntry{{file: "maps/Other.json", data: bundleMapJSON, unlisted: true}},
+		},
+		{
+			name:      "same as installed",
+			installed: map[string]string{"maps/Test.json": bundleMapJSON},
+			entries:   []bundleEntry{{file: "maps/Test.json", data: bundleMapJSON}},
+			report:    bundleReport{Unchanged: []string{"maps/Test.json"}},
+			files:     map[string]string{"maps/Test.json": bundleMapJSON},
+		},
+		{
+			name:      "conflict with installed",
+			installed: map[string]string{"maps/Test.json": `{"name": "Mine"}`, "icons/hub.png": "mine"},
+			entries:   []bundleEntry{{file: "maps/Test.json", data: bundleMapJSON}, {file: "icons/hub.png", data: "png", icon: true}},
+			conflicts: []string{"maps/Test.json", "icons/hub.png"},
+			files:     map[string]string{"maps/Test.json": `{"name": "Mine"}`, "icons/hub.png": "mine"},
+		},
+		{
+			name:      "conflict with installed in another format",
+			installed: map[string]string{"maps/Test.yaml": "name: Mine\n"},
+			entries:   []bundleEntry{{file: "maps/Test.json", data: bundleMapJSON}},
+			conflicts: []string{"maps/Test.json"},
+			files:     map[string]string{"maps/Test.yaml": "name: Mine\n"},
+		},
+		{
+			name:      "forced over installed",
+			installed: map[string]string{"maps/Test.json": `{"name": "Mine"}`},
+			entries:   []bundleEntry{{file: "maps/Test.json", data: bundleMapJSON}},
+			force:     true,
+			report:    bundleReport{Installed: []string{"maps/Test.json"}},
+			files:     map[string]string{"maps/Test.json": bundleMapJSON},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := inTempDir(t)
+			for f, data := range tt.installed {
+				err := os.MkdirAll(filepath.Dir(f), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(f, []byte(data), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := filepath.Join(dir, "bundle.zip")
+			writeTestBundle(t, p, tt.entries)
+
+			report, err := ImportBundle(p, tt.force)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Conflicts name the absolute path of the installed file, so only their bundled file is compared
+			var conflicts []string
+			for _, c := range report.Conflicts {
+				conflicts = append(conflicts, strings.SplitN(c, ":", 2)[0])
+			}
+			report.Conflicts = nil
+			if !reflect.DeepEqual(report, tt.report) {
+				t.Errorf("report = %+v, want %+v", report, tt.report)
+			}
+			if !reflect.DeepEqual(conflicts, tt.conflicts) {
+				t.Errorf("conflicts = %v, want %v", conflicts, tt.conflicts)
+			}
+
+			for f, want := range tt.files {
+				data, err := os.ReadFile(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("%s = %q, want %q", f, data, want)
+				}
+			}
+			for _, f := range []string{filepath.Join(dir, "..", "evil.json"), filepath.Join(dir, "..", "evil.png"), "/tmp/evil.png"} {
+				_, err := os.Stat(f)
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("bundle wrote %s outside its directories", f)
+				}
+			}
+		})
+	}
+}
+
+func TestImportBundleManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{"no manifest", ""},
+		{"invalid manifest", "{"},
+		{"newer format", `{"format": 99}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := inTempDir(t)
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			if tt.manifest != "" {
+				err := writeBundleFile(zw, bundleManifestFile, []byte(tt.manifest))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := zw.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := filepath.Join(dir, "bundle.zip")
+			err = os.WriteFile(p, buf.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ImportBundle(p, false)
+			if err == nil {
+				t.Error("ImportBundle accepted the bundle")
+			}
+		})
+	}
+}
+
+func TestWriteBundleRoundTrip(t *testing.T) {
+	dir := inTempDir(t)
+	em := testMapper()
+	err := os.MkdirAll(mapsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(mapsDir, "Test.json"), []byte(bundleMapJSON), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := em.LoadMap("Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = em.WriteBundle(&buf, []string{"Test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "bundle.zip")
+	err = os.WriteFile(p, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.RemoveAll(mapsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := ImportBundle(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Installed, []string{"maps/Test.json"}) || len(report.Failed)+len(report.Conflicts) > 0 {
+		t.Fatalf("report = %+v, want the map installed", report)
+	}
+
+	got, err := em.LoadMap("Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Systems, want.Systems) {
+		t.Errorf("imported systems = %v, want %v", got.Systems, want.Systems)
+	}
+}
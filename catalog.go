@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sourceDotlan     = "dotlan"
+	sourceHandMade   = "hand-made"
+	sourceAutoLayout = "auto-layout"
+)
+
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+type (
+	// catalogEntry describes a single map of the maps and overlays dirs
+	catalogEntry struct {
+		Map            string    `json:"map"`
+		Name           string    `json:"name"`
+		Author         string    `json:"author,omitempty"`
+		Description    string    `json:"description,omitempty"`
+		Version        string    `json:"version,omitempty"`
+		Tags           []string  `json:"tags,omitempty"`
+		Modified       time.Time `json:"modified"`
+		Source         string    `json:"source,omitempty"`
+		License        string    `json:"license,omitempty"`
+		Regions        []int32   `json:"regions,omitempty"`
+		Constellations []int32   `json:"constellations,omitempty"`
+		Systems        int       `json:"systems"`
+		ContentHash    string    `json:"content_hash,omitempty"`
+
+		// Error is set instead of most of the above when the map failed to load
+		Error string `json:"error,omitempty"`
+	}
+
+	// catalogQuery filters the catalog, every field that is set must match
+	catalogQuery struct {
+		Text    string
+		Tags    []string
+		Source  string
+		Author  string
+		License string
+		Region  string
+	}
+)
+
+// Catalog describes every map that can be loaded, ordered by name
+func (em *EveMapper) Catalog() ([]catalogEntry, error) {
+	names, err := listMaps()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]catalogEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, em.CatalogEntry(name))
+	}
+
+	return entries, nil
+}
+
+// CatalogEntry describes the named map, problems loading it are recorded in the entry
+func (em *EveMapper) CatalogEntry(name string) catalogEntry {
+	m, err := em.LoadMap(name)
+	if err != nil {
+		return catalogEntry{Map: name, Name: name, Error: err.Error()}
+	}
+
+	return em.describeMap(name, m)
+}
+
+// describeMap builds the catalog entry of m, which was loaded from the named map
+func (em *EveMapper) describeMap(name string, m spyglassMap) catalogEntry {
+	e := catalogEntry{Map: name}
+
+	e.Name = m.Name
+	e.Author = m.Author
+	e.Description = m.Description
+	e.Version = m.Version
+	e.Tags = m.Tags
+	e.Source = m.Source
+	e.License = m.License
+	e.Systems = len(m.Systems)
+	e.Regions, e.Constellations = em.mapCoverage(m)
+	e.ContentHash = contentHash(m)
+
+	if m.Modified != nil {
+		e.Modified = *m.Modified
+	} else {
+		e.Modified = mapModTime(name)
+	}
+
+	if e.Version != "" && !semverPattern.MatchString(e.Version) {
+		e.Error = fmt.Sprintf("version '%s' is not a semantic version", e.Version)
+	}
+
+	return e
+}
+
+// mapCoverage returns the regions and constellations of m, as listed in the map or else as covered by its own systems
+func (em *EveMapper) mapCoverage(m spyglassMap) ([]int32, []int32) {
+	if len(m.Regions) > 0 && len(m.Constellations) > 0 {
+		return m.Regions, m.Constellations
+	}
+
+	regions := make(map[int32]bool)
+	constellations := make(map[int32]bool)
+	for id, s := range m.Systems {
+		if s.External {
+			continue
+		}
+		info, ok := em.Systems[id]
+		if !ok {
+			continue
+		}
+		regions[info.RegionID] = true
+		constellations[info.ConstellationID] = true
+	}
+
+	r, c := m.Regions, m.Constellations
+	if len(r) == 0 {
+		r = sortedIDs(regions)
+	}
+	if len(c) == 0 {
+		c = sortedIDs(constellations)
+	}
+	return r, c
+}
+
+// contentHash identifies the content of a map with its includes and overlays applied, it changes whenever
+// any of the files making up the map do. The theme and live state such as intel are not part of it.
+func contentHash(m spyglassMap) string {
+	m.Modified = nil
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// mapModTime returns the last time the files making up a map were changed
+func mapModTime(name string) time.Time {
+	var latest time.Time
+	for _, dir := range []string{mapsDir, overlaysDir} {
+		p, err := findMapFile(dir, name)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest.UTC()
+}
+
+func sortedIDs(set map[int32]bool) []int32 {
+	ids := make([]int32, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// SearchCatalog returns the entries matching q
+func (em *EveMapper) SearchCatalog(entries []catalogEntry, q catalogQuery) []catalogEntry {
+	var region int32
+	if q.Region != "" {
+		id, err := strconv.Atoi(q.Region)
+		if err == nil {
+			region = int32(id)
+		} else {
+			region = em.regionID(q.Region)
+		}
+	}
+
+	var found []catalogEntry
+	for _, e := range entries {
+		if q.Text != "" && !containsFold(q.Text, e.Map, e.Name, e.Author, e.Description, strings.Join(e.Tags, " ")) {
+			continue
+		}
+		if !hasTags(e.Tags, q.Tags) {
+			continue
+		}
+		if q.Source != "" && !strings.EqualFold(q.Source, e.Source) {
+			continue
+		}
+		if q.Author != "" && !strings.EqualFold(q.Author, e.Author) {
+			continue
+		}
+		if q.License != "" && !strings.EqualFold(q.License, e.License) {
+			continue
+		}
+		if q.Region != "" && !hasID(e.Regions, region) {
+			continue
+		}
+		found = append(found, e)
+	}
+	return found
+}
+
+// regionID looks up a region by name, dotlan style names with underscores are accepted too
+func (em *EveMapper) regionID(name string) int32 {
+	name = strings.ReplaceAll(name, "_", " ")
+	for id, r := range em.Galaxy {
+		if strings.EqualFold(r.Name, name) {
+			return id
+		}
+	}
+	return 0
+}
+
+func containsFold(needle string, haystack ...string) bool {
+	needle = strings.ToLower(needle)
+	for _, h := range haystack {
+		if strings.Contains(strings.ToLower(h), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if strings.EqualFold(t, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func hasID(ids []int32, id int32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
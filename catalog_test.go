@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCatalogContentHash(t *testing.T) {
+	const (
+		east = `name: East
+width: 300
+height: 100
+systems:
+  3: {name: Charlie, x: 10, y: 10}
+`
+		comp = `name: Comp
+width: 700
+height: 100
+systems:
+  1: {name: Alpha, x: 10, y: 10}
+includes:
+  - {map: East, offset_x: 300}
+`
+	)
+
+	tests := []struct {
+		name    string
+		maps    map[string]string
+		changed bool
+	}{
+		{"same files", map[string]string{}, false},
+		{"modified time", map[string]string{"Comp.yaml": comp + "modified: 2026-01-02T03:04:05Z\n"}, false},
+		{"comments", map[string]string{"Comp.yaml": "# composite\n" + comp}, false},
+		{"own system moved", map[string]string{"Comp.yaml": `name: Comp
+width: 700
+height: 100
+systems:
+  1: {name: Alpha, x: 20, y: 10}
+includes:
+  - {map: East, offset_x: 300}
+`}, true},
+		{"included map changed", map[string]string{"East.yaml": east + "  4: {name: Delta, x: 100, y: 10}\n"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			em := testMapper()
+			writeTestMaps(t, map[string]string{"East.yaml": east, "Comp.yaml": comp})
+			before := em.CatalogEntry("Comp")
+			if before.Error != "" || before.ContentHash == "" {
+				t.Fatalf("catalog entry = %+v", before)
+			}
+
+			writeTestMaps(t, tt.maps)
+			after := em.CatalogEntry("Comp")
+			if changed := after.ContentHash != before.ContentHash; changed != tt.changed {
+				t.Errorf("content hash changed %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var errUsage = errors.New("wrong arguments")
+
+// commands are run from the command line as `spyglass_mapper <name> args...`, without one the map server is started
+var commands = map[string]command{
+	"convert": {
+		usage:       "convert <source> <destination>",
+		description: "convert a map file between json, yaml and toml, chosen by the file extensions",
+		run:         runConvert,
+	},
+	"bundle-export": {
+		usage:       "bundle-export [-o bundle.zip] [map...]",
+		description: "write a bundle of the named maps, or of all maps, for installing into spyglass",
+		run:         runBundleExport,
+	},
+	"bundle-import": {
+		usage:       "bundle-import [-force] <bundle.zip>",
+		description: "install the maps and icons of a bundle, replacing changed ones only with -force",
+		run:         runBundleImport,
+	},
+	"normalize": {
+		usage:       "normalize [-n] [-strip] [map...]",
+		description: "correct stale system names from New Eden and report ids that do not resolve, -n only reports",
+		run:         runNormalize,
+	},
+	"fit": {
+		usage:       "fit [-n] [-margin 20] [-grid 0] [map...]",
+		description: "move the content of maps to the origin, snap it to a grid and size the canvas around it",
+		run:         runFit,
+	},
+	"unoverlap": {
+		usage:       "unoverlap [-n] [-padding 4] [map...]",
+		description: "move systems apart that overlap each other or lie on a connection, -n only reports",
+		run:         runUnoverlap,
+	},
+	"diff": {
+		usage:       "diff <old> <new>",
+		description: "list the systems, connections and annotations that changed between two map files",
+		run:         runDiff,
+	},
+	"merge": {
+		usage:       "merge [-o output] <base> <ours> <theirs>",
+		description: "three way merge of map files, written to ours unless -o is given so it can serve as a git merge driver",
+		run:         runMerge,
+	},
+	"stubs": {
+		usage:       "stubs [-n] [-prune] [-padding 4] [map...]",
+		description: "add gate neighbours that are missing from maps as external systems and report stale external systems",
+		run:         runStubs,
+	},
+	"ingest-esi": {
+		usage:       "ingest-esi [-prefix name_] <file...>",
+		description: "turn saved ESI system_kills or system_jumps responses into datasets for heatmaps",
+		run:         runIngestESI,
+	},
+}
+
+func runCommand(name string, args []string) error {
+	if name == "help" {
+		printUsage()
+		return nil
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage()
+		return fmt.Errorf("unknown command '%s'", name)
+	}
+
+	err := cmd.run(args)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(os.Stderr, "usage: spyglass_mapper "+cmd.usage)
+	}
+	return err
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: spyglass_mapper [command]")
+	fmt.Fprintln(os.Stderr, "without a command the map server is started, the commands are:")
+	for _, n := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", commands[n].usage, commands[n].description)
+	}
+}
+
+func runConvert(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	return ConvertMapFile(args[0], args[1])
+}
+
+func runBundleExport(args []string) error {
+	fl := flag.NewFlagSet("bundle-export", flag.ContinueOnError)
+	out := fl.String("o", "spyglass_maps.zip", "file to write the bundle to")
+	err := fl.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	names := fl.Args()
+	if len(names) == 0 {
+		names, err = listMaps()
+		if err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	err = NewEveMapper().WriteBundle(&buf, names)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(*out, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Bundled %d maps into %s", len(names), *out)
+	return nil
+}
+
+func runBundleImport(args []string) error {
+	fl := flag.NewFlagSet("bundle-import", flag.ContinueOnError)
+	force := fl.Bool("force", false, "replace installed files that differ from the bundled ones")
+	err := fl.Parse(args)
+	if err != nil || fl.NArg() != 1 {
+		return errUsage
+	}
+
+	report, err := ImportBundle(fl.Arg(0), *force)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range report.Installed {
+		log.Printf("installed %s", f)
+	}
+	for _, f := range report.Unchanged {
+		log.Printf("unchanged %s", f)
+	}
+	for _, f := range report.Conflicts {
+		log.Printf("WARN: conflict %s", f)
+	}
+	for _, f := range report.Failed {
+		log.Printf("WARN: rejected %s", f)
+	}
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d files in the bundle were rejected", len(report.Failed))
+	}
+	return nil
+}
+
+func runNormalize(args []string) error {
+	fl := flag.NewFlagSet("normalize", flag.ContinueOnError)
+	dry := fl.Bool("n", false, "only report, do not rewrite any files")
+	strip := fl.Bool("strip", false, "drop every name that resolves, leaving id only systems")
+	err := fl.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	paths, err := mapFiles(fl.Args())
+	if err != nil {
+		return err
+	}
+
+	em := NewEveMapper()
+	for _, p := range paths {
+		report, err := em.NormalizeMapFile(p, *strip, !*dry)
+		if err != nil {
+			return err
+		}
+		for _, r := range report {
+			log.Printf("%s: %s", filepath.Base(p), r)
+		}
+	}
+
+	return nil
+}
+
+func runFit(args []string) error {
+	fl := flag.NewFlagSet("fit", flag.ContinueOnError)
+	dry := fl.Bool("n", false, "only report, do not rewrite any files")
+	margin := fl.Int("margin", defaultMargin, "space to leave around the content")
+	grid := fl.Int("grid", 0, "snap positions to multiples of this, 0 to leave them as they are")
+	err := fl.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	names := fl.Args()
+	if len(names) == 0 {
+		names, err = listMaps()
+		if err != nil {
+			return err
+		}
+	}
+
+	em := NewEveMapper()
+	for _, name := range names {
+		// Overlays have no canvas of their own
+		p, err := findMapFile(mapsDir, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		m, err := em.FitMapFile(p, int32(*margin), int32(*grid), !*dry)
+		if errors.Is(err, errSharedLayout) {
+			log.Printf("WARN: skipping %s: %v", name, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fit '%s': %w", name, err)
+		}
+		log.Printf("%s: %dx%d", filepath.Base(p), m.Width, m.Height)
+	}
+
+	return nil
+}
+
+func runUnoverlap(args []string) error {
+	fl := flag.NewFlagSet("unoverlap", flag.ContinueOnError)
+	dry := fl.Bool("n", false, "only report, do not rewrite any files")
+	padding := fl.Int("padding", defaultPadding, "space to keep between systems and connections")
+	err := fl.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	names := fl.Args()
+	if len(names) == 0 {
+		names, err = listMaps()
+		if err != nil {
+			return err
+		}
+	}
+
+	em := NewEveMapper()
+	for _, name := range names {
+		p, err := findMapFile(mapsDir, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		report, err := em.ResolveOverlapsFile(p, int32(*padding), !*dry)
+		if errors.Is(err, errSharedLayout) {
+			log.Printf("WARN: skipping %s: %v", name, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resolve overlaps of '%s': %w", name, err)
+		}
+
+		base := filepath.Base(p)
+		for _, o := range report.Found {
+			log.Printf("%s: %s", base, o)
+		}
+		for _, id := range sortedIDs(movedSet(report.Moves)) {
+			mv := report.Moves[id]
+			log.Printf("%s: move system %d by %d,%d", base, id, mv[0], mv[1])
+		}
+		for _, o := range report.Remaining {
+			log.Printf("WARN: %s: unresolved, %s", base, o)
+		}
+	}
+
+	return nil
+}
+
+func movedSet(moves map[int32][2]int32) map[int32]bool {
+	set := make(map[int32]bool, len(moves))
+	for id := range moves {
+		set[id] = true
+	}
+	return set
+}
+
+func runDiff(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	a, err := readRawMap(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := readRawMap(args[1])
+	if err != nil {
+		return err
+	}
+
+	for _, l := range DiffMaps(a, b).Lines() {
+		fmt.Println(l)
+	}
+	return nil
+}
+
+func runMerge(args []string) error {
+	fl := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fl.String("o", "", "file to write the merged map to, defaults to ours")
+	err := fl.Parse(args)
+	if err != nil || fl.NArg() != 3 {
+		return errUsage
+	}
+
+	maps := make([]spyglassMap, 3)
+	for i, p := range fl.Args() {
+		err = checkPlainMap(p)
+		if err != nil {
+			return err
+		}
+		maps[i], err = readRawMap(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	merged, conflicts := MergeMaps(maps[0], maps[1], maps[2])
+
+	dest := *out
+	if dest == "" {
+		dest = fl.Arg(1)
+	}
+	err = patchMapFile(fl.Arg(1), dest, maps[1], merged)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range conflicts {
+		log.Printf("CONFLICT: %s, kept ours", c)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%d conflicts merging %s", len(conflicts), filepath.Base(fl.Arg(1)))
+	}
+	return nil
+}
+
+func runStubs(args []string) error {
+	fl := flag.NewFlagSet("stubs", flag.ContinueOnError)
+	dry := fl.Bool("n", false, "only report, do not rewrite any files")
+	prune := fl.Bool("prune", false, "remove external systems that no longer have a gate into the map")
+	padding := fl.Int("padding", defaultPadding, "space to keep between systems and connections")
+	err := fl.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	names := fl.Args()
+	if len(names) == 0 {
+		names, err = listMaps()
+		if err != nil {
+			return err
+		}
+	}
+
+	em := NewEveMapper()
+	for _, name := range names {
+		p, err := findMapFile(mapsDir, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		report, err := em.AddExternalStubsFile(p, int32(*padding), *prune, !*dry)
+		if errors.Is(err, errSharedLayout) {
+			log.Printf("WARN: skipping %s: %v", name, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add stubs to '%s': %w", name, err)
+		}
+
+		base := filepath.Base(p)
+		for _, s := range report.Added {
+			log.Printf("%s: added %s (%s) at %d,%d", base, s.Name, em.Systems[s.ID].Region, s.X, s.Y)
+		}
+		for _, id := range report.Unplaced {
+			log.Printf("WARN: %s: no room for %s next to its neighbour", base, em.Systems[id].Name)
+		}
+		for _, id := range report.Stale {
+			log.Printf("WARN: %s: external system %d has no gate into the map", base, id)
+		}
+	}
+
+	return nil
+}
+
+func runIngestESI(args []string) error {
+	fl := flag.NewFlagSet("ingest-esi", flag.ContinueOnError)
+	prefix := fl.String("prefix", "", "put before the names of the datasets, such as the date of the data")
+	err := fl.Parse(args)
+	if err != nil || fl.NArg() == 0 {
+		return errUsage
+	}
+
+	for _, p := range fl.Args() {
+		names, err := IngestESI(p, *prefix)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			log.Printf("%s: wrote dataset %s", filepath.Base(p), name)
+		}
+	}
+	return nil
+}
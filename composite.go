@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// mergeIncludes flattens every map included by mp onto its canvas.
+// Included systems are scaled and then offset, optionally limited to the listed system ids.
+// A system present more than once is kept only once: systems defined by mp itself keep their position,
+// otherwise the first copy wins. An external stub is promoted to a real system whenever another
+// included map holds that system as one of its own. The map wide style of an included map becomes a class
+// in front of the classes of its systems, so they keep the look they have on their own map.
+func (em *EveMapper) mergeIncludes(mp spyglassMap, seen []string) (spyglassMap, error) {
+	own := make(map[int32]bool, len(mp.Systems))
+	for id := range mp.Systems {
+		own[id] = true
+	}
+
+	growWidth := mp.Width == 0
+	growHeight := mp.Height == 0
+
+	for _, inc := range mp.Includes {
+		sub, err := em.loadMap(inc.Map, seen)
+		if err != nil {
+			return mp, fmt.Errorf("failed to include map '%s': %w", inc.Map, err)
+		}
+
+		scale := inc.Scale
+		if scale == 0 {
+			scale = 1
+		}
+
+		var filter map[int32]bool
+		if len(inc.Systems) > 0 {
+			filter = make(map[int32]bool, len(inc.Systems))
+			for _, id := range inc.Systems {
+				filter[id] = true
+			}
+		}
+
+		var styleClass string
+		if sub.Style != nil {
+			styleClass = includeStyleClass(inc.Map)
+			if mp.Classes == nil {
+				mp.Classes = make(map[string]spyglassStyle)
+			}
+			mp.Classes[styleClass] = *sub.Style
+		}
+
+		for id, s := range sub.Systems {
+			if filter != nil && !filter[id] {
+				continue
+			}
+
+			s.X = scaleCoord(s.X, scale) + inc.OffsetX
+			s.Y = scaleCoord(s.Y, scale) + inc.OffsetY
+			if styleClass != "" {
+				s.Classes = append([]string{styleClass}, s.Classes...)
+			}
+
+			existing, ok := mp.Systems[id]
+			switch {
+			case !ok:
+				mp.Systems[id] = s
+			case existing.External && !s.External:
+				if own[id] {
+					existing.External = false
+					mp.Systems[id] = existing
+				} else {
+					mp.Systems[id] = s
+				}
+			}
+		}
+
+		for _, c := range sub.Connections {
+			if filter == nil || (filter[c.From] && filter[c.To]) {
+				mp.Connections = append(mp.Connections, c)
+			}
+		}
+
+		for _, p := range sub.PointsOfInterest {
+			if filter == nil || filter[p.System] {
+				mp.PointsOfInterest = append(mp.PointsOfInterest, p)
+			}
+		}
+
+		// Annotations of a partial include most likely describe systems that were left out
+		if filter == nil {
+			for _, a := range sub.Annotations {
+				a.X = scaleCoord(a.X, scale) + inc.OffsetX
+				a.Y = scaleCoord(a.Y, scale) + inc.OffsetY
+				mp.Annotations = append(mp.Annotations, a)
+			}
+		}
+
+		// Included systems still refer to the style classes of their own map
+		for name, c := range sub.Classes {
+			if _, ok := mp.Classes[name]; ok {
+				continue
+			}
+			if mp.Classes == nil {
+				mp.Classes = make(map[string]spyglassStyle)
+			}
+			mp.Classes[name] = c
+		}
+
+		mp.Conflicts = append(mp.Conflicts, sub.Conflicts...)
+
+		if w := scaleCoord(sub.Width, scale) + inc.OffsetX; growWidth && w > mp.Width {
+			mp.Width = w
+		}
+		if h := scaleCoord(sub.Height, scale) + inc.OffsetY; growHeight && h > mp.Height {
+			mp.Height = h
+		}
+	}
+
+	mp.Includes = nil
+
+	return mp, nil
+}
+
+// includeStyleClass names the class holding the map wide style of an included map
+func includeStyleClass(name string) string {
+	return "include:" + name
+}
+
+func scaleCoord(c int32, scale float64) int32 {
+	return int32(math.Round(float64(c) * scale))
+}
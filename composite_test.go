@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestMaps puts maps into the maps dir of the working directory
+func writeTestMaps(t *testing.T, maps map[string]string) {
+	t.Helper()
+	err := os.MkdirAll(mapsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, doc := range maps {
+		err = os.WriteFile(filepath.Join(mapsDir, name), []byte(doc), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMergeIncludes(t *testing.T) {
+	// West holds Charlie as a stub into the east, East holds it for real
+	parts := map[string]string{
+		"West.yaml": `name: West
+width: 300
+height: 100
+systems:
+  1: {name: Alpha, x: 10, y: 10}
+  2: {name: Bravo, x: 100, y: 10}
+  3: {name: Charlie, x: 200, y: 10, external: true}
+connections:
+  - {from: 1, to: 2}
+`,
+		"East.yaml": `name: East
+width: 300
+height: 100
+style: {fill: "rgb(255,0,0)"}
+classes:
+  hub: {fill: "rgb(0,0,255)"}
+systems:
+  3: {name: Charlie, x: 10, y: 10}
+  4: {name: Delta, x: 100, y: 10, classes: [hub]}
+  2: {name: Bravo, x: 200, y: 10, external: true}
+`,
+	}
+
+	type pos [2]int32
+	tests := []struct {
+		name     string
+		comp     string
+		systems  map[int32]pos
+		external []int32
+	}{
+		{
+			name: "stub promoted by a later include",
+			comp: `name: Comp
+width: 700
+height: 100
+includes:
+  - {map: West}
+  - {map: East, offset_x: 300}
+`,
+			systems: map[int32]pos{1: {10, 10}, 2: {100, 10}, 3: {310, 10}, 4: {400, 10}},
+		},
+		{
+			name: "stub promoted by an earlier include",
+			comp: `name: Comp
+width: 700
+height: 100
+includes:
+  - {map: East, offset_x: 300}
+  - {map: West}
+`,
+			systems: map[int32]pos{1: {10, 10}, 2: {100, 10}, 3: {310, 10}, 4: {400, 10}},
+		},
+		{
+			name: "own systems keep their position",
+			comp: `name: Comp
+width: 700
+height: 100
+systems:
+  2: {name: Bravo, x: 50, y: 50}
+  3: {name: Charlie, x: 150, y: 50, external: true}
+includes:
+  - {map: West}
+  - {map: East, offset_x: 300}
+`,
+			systems: map[int32]pos{1: {10, 10}, 2: {50, 50}, 3: {150, 50}, 4: {400, 10}},
+		},
+		{
+			name: "first copy wins",
+			comp: `name: Comp
+width: 700
+height: 100
+includes:
+  - {map: West}
+  - {map: West, offset_x: 300, offset_y: 50}
+`,
+			systems:  map[int32]pos{1: {10, 10}, 2: {100, 10}, 3: {200, 10}},
+			external: []int32{3},
+		},
+		{
+			name: "partial include",
+			comp: `name: Comp
+width: 700
+height: 100
+includes:
+  - {map: West, systems: [1, 3]}
+`,
+			systems:  map[int32]pos{1: {10, 10}, 3: {200, 10}},
+			external: []int32{3},
+		},
+		{
+			name: "scaled include",
+			comp: `name: Comp
+width: 700
+height: 100
+includes:
+  - {map: East, scale: 0.5, offset_x: 5}
+`,
+			systems:  map[int32]pos{2: {105, 5}, 3: {10, 5}, 4: {55, 5}},
+			external: []int32{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			writeTestMaps(t, parts)
+			writeTestMaps(t, map[string]string{"Comp.yaml": tt.comp})
+
+			mp, err := testMapper().LoadMap("Comp")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[int32]pos, len(mp.Systems))
+			var external []int32
+			for _, id := range systemIDs(mp.Systems) {
+				s := mp.Systems[id]
+				got[id] = pos{s.X, s.Y}
+				if s.External {
+					external = append(external, id)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.systems) {
+				t.Errorf("systems at %v, want %v", got, tt.systems)
+			}
+			if !reflect.DeepEqual(external, tt.external) {
+				t.Errorf("external systems = %v, want %v", external, tt.external)
+			}
+			if len(mp.Includes) != 0 {
+				t.Errorf("includes were left on the map: %v", mp.Includes)
+			}
+		})
+	}
+}
+
+func TestMergeIncludesStyle(t *testing.T) {
+	inTempDir(t)
+	writeTestMaps(t, map[string]string{
+		"East.yaml": `name: East
+width: 300
+height: 100
+style: {fill: "rgb(255,0,0)", stroke: "rgb(0,255,0)"}
+classes:
+  hub: {fill: "rgb(0,0,255)"}
+systems:
+  3: {name: Charlie, x: 10, y: 10}
+  4: {name: Delta, x: 100, y: 10, classes: [hub]}
+`,
+		"Comp.yaml": `name: Comp
+width: 700
+height: 100
+style: {fill: "rgb(200,200,200)", stroke_width: 3}
+systems:
+  1: {name: Alpha, x: 400, y: 10}
+includes:
+  - {map: East}
+`,
+	})
+
+	mp, err := testMapper().LoadMap("Comp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id          int32
+		fill        string
+		stroke      string
+		strokeWidth float64
+	}{
+		{1, "rgb(200,200,200)", defaultSystemStyle.Stroke, 3},
+		{3, "rgb(255,0,0)", "rgb(0,255,0)", 3},
+		// The classes of a system still go over the style of its map
+		{4, "rgb(0,0,255)", "rgb(0,255,0)", 3},
+	}
+	for _, tt := range tests {
+		st, err := mp.SystemStyle(mp.Systems[tt.id])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Fill != tt.fill || st.Stroke != tt.stroke || float64(st.StrokeWidth) != tt.strokeWidth {
+			t.Errorf("system %d drawn with %s, %s, %v, want %s, %s, %v",
+				tt.id, st.Fill, st.Stroke, st.StrokeWidth, tt.fill, tt.stroke, tt.strokeWidth)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+)
+
+const (
+	// Gate connections are told apart by what they cross, like dotlan does
+	jumpConstellation      = "constellation"
+	jumpInterConstellation = "inter-constellation"
+	jumpInterRegion        = "inter-region"
+)
+
+// mapConnection is a line drawn between two systems, From is always the lower system id
+type mapConnection struct {
+	From int32
+	To   int32
+	Type string
+}
+
+// MapConnections returns every connection drawn on mp once, the gates from New Eden between systems on the map
+// as well as the custom connections of the map. A custom connection with a type replaces a gate between the same systems,
+// one without a type is classified like a gate.
+func (em *EveMapper) MapConnections(mp spyglassMap) []mapConnection {
+	byPair := make(map[[2]int32]string)
+	add := func(a, b int32, kind string) {
+		if a == b {
+			return
+		}
+		if _, ok := mp.Systems[a]; !ok {
+			return
+		}
+		if _, ok := mp.Systems[b]; !ok {
+			return
+		}
+		if a > b {
+			a, b = b, a
+		}
+		pair := [2]int32{a, b}
+		if kind == "" {
+			if _, ok := byPair[pair]; ok {
+				return
+			}
+			kind = em.gateType(a, b)
+		}
+		byPair[pair] = kind
+	}
+
+	for _, id := range systemIDs(mp.Systems) {
+		for _, next := range em.neighbours(id) {
+			add(id, next, "")
+		}
+	}
+	for _, c := range mp.Connections {
+		add(c.From, c.To, c.Type)
+	}
+
+	out := make([]mapConnection, 0, len(byPair))
+	for pair, kind := range byPair {
+		out = append(out, mapConnection{From: pair[0], To: pair[1], Type: kind})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].From != out[j].From {
+			return out[i].From < out[j].From
+		}
+		return out[i].To < out[j].To
+	})
+	return out
+}
+
+// gateType classifies a jump between two systems by whether it stays in the constellation or region
+func (em *EveMapper) gateType(a, b int32) string {
+	ia, aok := em.Systems[a]
+	ib, bok := em.Systems[b]
+	switch {
+	case !aok || !bok:
+		return jumpGate
+	case ia.RegionID != ib.RegionID:
+		return jumpInterRegion
+	case ia.ConstellationID != ib.ConstellationID:
+		return jumpInterConstellation
+	}
+	return jumpConstellation
+}
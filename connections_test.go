@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapConnections(t *testing.T) {
+	em := testMapper()
+	onMap := func(ids ...int32) map[int32]spyglassSystem {
+		systems := make(map[int32]spyglassSystem, len(ids))
+		for _, id := range ids {
+			systems[id] = spyglassSystem{ID: id}
+		}
+		return systems
+	}
+
+	tests := []struct {
+		name  string
+		mp    spyglassMap
+		conns []mapConnection
+	}{
+		{
+			name: "gates between systems on the map",
+			mp:   spyglassMap{Systems: onMap(1, 2, 3, 4)},
+			conns: []mapConnection{
+				{From: 1, To: 2, Type: jumpConstellation},
+				{From: 2, To: 3, Type: jumpInterConstellation},
+				{From: 3, To: 4, Type: jumpInterRegion},
+			},
+		},
+		{
+			name: "custom connection replaces the gate",
+			mp: spyglassMap{
+				Systems:     onMap(1, 2),
+				Connections: []spyglassConnection{{From: 2, To: 1, Type: jumpBridge}},
+			},
+			conns: []mapConnection{{From: 1, To: 2, Type: jumpBridge}},
+		},
+		{
+			name: "custom connection without a type is classified like a gate",
+			mp: spyglassMap{
+				Systems:     onMap(1, 2, 8),
+				Connections: []spyglassConnection{{From: 1, To: 2}, {From: 8, To: 2}},
+			},
+			conns: []mapConnection{
+				{From: 1, To: 2, Type: jumpConstellation},
+				{From: 2, To: 8, Type: jumpInterRegion},
+			},
+		},
+		{
+			name: "connections leaving the map and to itself are dropped",
+			mp: spyglassMap{
+				Systems:     onMap(1, 8),
+				Connections: []spyglassConnection{{From: 1, To: 7, Type: jumpWormhole}, {From: 8, To: 8}},
+			},
+			conns: []mapConnection{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := em.MapConnections(tt.mp)
+			if !reflect.DeepEqual(got, tt.conns) {
+				t.Errorf("MapConnections = %v, want %v", got, tt.conns)
+			}
+		})
+	}
+}
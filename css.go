@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	// Ways of putting the theme into a rendered map: inline styles on every element, or semantic classes
+	// with the theme as one stylesheet that clients can replace
+	cssInline  = "inline"
+	cssClasses = "classes"
+)
+
+// painter gives the elements of a rendered map their look from the theme, inline or by class
+type painter struct {
+	theme   mapTheme
+	classes bool
+}
+
+// attrs returns the attributes of an element of the given classes. Inline it carries the themed style the
+// stylesheet has for those classes, the inline declarations depend on the element itself and are kept either way.
+func (p painter) attrs(classes, themed string, inline ...string) []string {
+	if p.classes {
+		return classAttrs(classes, inline...)
+	}
+	style := []string{themed}
+	for _, s := range inline {
+		if s != "" {
+			style = append(style, s)
+		}
+	}
+	return []string{strings.Join(style, ";")}
+}
+
+// text returns the attributes of a text of the given class, inline in the theme font with the colour and the
+// themed declarations the stylesheet has for the class
+func (p painter) text(class, color string, size int32, anchor, themed string) []string {
+	if p.classes {
+		return textAttrs(class, size, anchor)
+	}
+	if anchor != "" {
+		themed = strings.TrimPrefix(themed+";text-anchor:"+anchor, ";")
+	}
+	return []string{p.theme.text(color, size, themed)}
+}
+
+// jumpClass is the class of a connection of the given type
+func jumpClass(kind string) string {
+	if kind == jumpInterRegion {
+		return "jump--region"
+	}
+	return "jump--" + kind
+}
+
+// stylesheet returns the theme as css for maps rendered with classes
+func (t mapTheme) stylesheet() string {
+	var sb strings.Builder
+	rule := func(selector string, decls ...string) {
+		fmt.Fprintf(&sb, "%s { %s }\n", selector, strings.Join(decls, "; "))
+	}
+
+	if t.Font != "" {
+		rule("text", "font-family: "+t.Font)
+	}
+	rule(".background", "fill: "+t.Background, "stroke: "+t.Border, "stroke-width: 1px")
+	rule(".system", "fill: "+t.System.Fill, "stroke: "+t.System.Stroke, fmt.Sprintf("stroke-width: %gpx", t.System.StrokeWidth))
+	for _, state := range []string{statusClear, statusAlarm, statusStale} {
+		if c, ok := t.Status[state]; ok {
+			rule(".system--"+state, "fill: "+c)
+		}
+	}
+	rule(".label-name, .label-status, .annotation", "fill: "+t.Text)
+
+	kinds := make([]string, 0, len(t.Jumps))
+	for kind := range t.Jumps {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	// Plain gates are the fallback for every type the theme has no line for, so they come before the types
+	rule(".jump", append([]string{"fill: none"}, cssDecls(t.jump(jumpGate).style())...)...)
+	for _, kind := range kinds {
+		if kind != jumpGate {
+			rule("."+jumpClass(kind), cssDecls(t.Jumps[kind].style())...)
+		}
+	}
+
+	for _, band := range []string{secHigh, secLow, secNull} {
+		rule(".security--"+band, cssDecls(t.shadeStyle(band))...)
+	}
+	rule(".hull", cssDecls(t.hullStyle())...)
+	rule(".label-constellation", "fill: "+t.MutedText, "font-style: italic")
+
+	rule(".note-dot", cssDecls(t.noteStyle())...)
+	for _, urgency := range timerUrgencies {
+		rule(".timer-badge--"+urgency, cssDecls(t.timerStyle(urgency))...)
+	}
+	rule(".timer-text", "fill: "+t.timerColor(timerText))
+
+	// Kinds without a colour of their own keep the default of the plain classes
+	rule(".poi-ring", cssDecls(t.poiRingStyle(defaultPOIColor))...)
+	rule(".poi-marker", cssDecls(t.poiMarkerStyle(defaultPOIColor))...)
+	for _, kind := range t.poiKinds() {
+		c := t.poiColor(spyglassPOI{Kind: kind})
+		rule(".poi-ring--"+kind, "stroke: "+c)
+		rule(".poi-marker--"+kind, "fill: "+c)
+	}
+	rule(".poi-symbol", "fill: "+t.Border, "font-weight: bold")
+	rule(".poi-pole", "stroke: "+t.Border, "stroke-width: 1px")
+
+	rule(".route-line", cssDecls(t.routeLineStyle())...)
+	rule(".route-halo", cssDecls(t.routeHaloStyle())...)
+	rule(".route-hop", cssDecls(t.routeHopStyle())...)
+	rule(".route-hop-number", "fill: "+t.Border, "font-weight: bold")
+	rule(".route-exit", cssDecls(t.routeExitStyle())...)
+	rule(".route-summary", "fill: "+t.Text, "font-weight: bold")
+	rule(".focus-count", "fill: "+t.Text, "font-weight: bold")
+
+	rule(".heat-patch", cssDecls(heatPatchStyle)...)
+	rule(".heat-circle", cssDecls(heatCircleStyle)...)
+	rule(".legend", cssDecls(t.legendStyle())...)
+	rule(".legend-title", "fill: "+t.Text, "font-weight: bold")
+	rule(".legend-label", "fill: "+t.Text)
+	rule(".legend-swatch", "stroke: none")
+	return sb.String()
+}
+
+// cssDecls splits an inline style into declarations
+func cssDecls(style string) []string {
+	return strings.Split(strings.ReplaceAll(style, ":", ": "), ";")
+}
+
+// classAttrs returns the attributes of an element drawn with classes, with whatever the stylesheet cannot know inline
+func classAttrs(classes string, inline ...string) []string {
+	attrs := []string{attr("class", classes)}
+	var style []string
+	for _, s := range inline {
+		if s != "" {
+			style = append(style, s)
+		}
+	}
+	if len(style) > 0 {
+		attrs = append(attrs, attr("style", strings.Join(style, ";")))
+	}
+	return attrs
+}
+
+// textAttrs are the attributes of a text drawn with classes. Size and anchor are presentation attributes
+// so that a stylesheet can still change them
+func textAttrs(class string, size int32, anchor string) []string {
+	attrs := []string{attr("class", class), attr("font-size", fmt.Sprintf("%dpx", size))}
+	if anchor != "" {
+		attrs = append(attrs, attr("text-anchor", anchor))
+	}
+	return attrs
+}
+
+// checkStylesheet accepts the url of a stylesheet to link from a map, either http(s) or relative, with nothing
+// in it that could break out of the processing instruction it is written into
+func checkStylesheet(ref string) error {
+	invalid := fmt.Errorf("stylesheet is not an http(s) or relative url: %w", errRenderOption)
+	if strings.ContainsAny(ref, `<>()"'`) || strings.Contains(ref, "]]>") || strings.IndexFunc(ref, unicode.IsSpace) >= 0 {
+		return invalid
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Opaque != "" {
+		return invalid
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return invalid
+	}
+	return nil
+}
+
+// linkStylesheet puts the processing instruction linking a stylesheet right after the xml declaration of a map
+func linkStylesheet(out, ref string) string {
+	pi := fmt.Sprintf(`<?xml-stylesheet type="text/css" href="%s"?>`, html.EscapeString(ref))
+	decl := strings.Index(out, "?>") + len("?>")
+	return out[:decl] + "\n" + pi + out[decl:]
+}
+
+// viewStylesheet serves a theme as the stylesheet for maps rendered with classes
+func (em *EveMapper) viewStylesheet(w http.ResponseWriter, r *http.Request) {
+	theme, err := LoadTheme(chi.URLParam(r, "theme"))
+	if err != nil {
+		w.WriteHeader(mapErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write([]byte(theme.stylesheet()))
+}
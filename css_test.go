@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// renderMapper is a test mapper able to render maps, without intel or notes
+func renderMapper() *EveMapper {
+	em := testMapper()
+	em.Notes = &noteStore{}
+	em.Status = staticStatusProvider{}
+	return em
+}
+
+func TestCheckStylesheet(t *testing.T) {
+	tests := []struct {
+		ref string
+		ok  bool
+	}{
+		{"https://example.com/themes/dark.css", true},
+		{"http://example.com/dark.css?v=2", true},
+		{"/themes/dark.css", true},
+		{"dark.css", true},
+		{"x]]></style><script>alert(1)</script>", false},
+		{"javascript:alert(1)", false},
+		{"data:text/css,svg{}", false},
+		{"file:///etc/passwd", false},
+		{`dark.css" onload="alert(1)`, false},
+		{"dark.css'", false},
+		{"dark .css", false},
+		{"dark.css\n", false},
+		{"url(dark.css)", false},
+		{"dark.css>", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			err := checkStylesheet(tt.ref)
+			if (err == nil) != tt.ok {
+				t.Errorf("checkStylesheet(%q) = %v, want ok %v", tt.ref, err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, errRenderOption) {
+				t.Errorf("checkStylesheet(%q) = %v, want an invalid render option", tt.ref, err)
+			}
+		})
+	}
+}
+
+func TestCreateMapSVGStylesheet(t *testing.T) {
+	inTempDir(t)
+	em := renderMapper()
+	mp := spyglassMap{Name: "Test", Width: 200, Height: 100, Systems: map[int32]spyglassSystem{
+		1: {ID: 1, Name: "Alpha", X: 10, Y: 10},
+	}}
+
+	_, err := em.CreateMapSVG(mp, renderOptions{Stylesheet: "x]]></style><script>alert(1)</script>"})
+	if !errors.Is(err, errRenderOption) {
+		t.Fatalf("CreateMapSVG with an injected stylesheet = %v, want an invalid render option", err)
+	}
+
+	out, err := em.CreateMapSVG(mp, renderOptions{Stylesheet: "/themes/dark.css?a=1&b=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pi = `<?xml-stylesheet type="text/css" href="/themes/dark.css?a=1&amp;b=2"?>`
+	if !strings.HasPrefix(out, "<?xml version=\"1.0\"?>\n"+pi+"\n") {
+		t.Errorf("map does not link the stylesheet after the xml declaration:\n%s", out)
+	}
+	if strings.Contains(out, "<style") {
+		t.Errorf("map with a linked stylesheet embeds one as well:\n%s", out)
+	}
+	if !strings.Contains(out, `class="background"`) {
+		t.Errorf("map with a linked stylesheet is not drawn with classes:\n%s", out)
+	}
+}
@@ -4,14 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
-	"io/fs"
 	"log"
-	"math/rand"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -21,25 +18,84 @@ import (
 type (
 	EveMapper struct{
 		Galaxy NewEden
+		// Systems indexes every system of the Galaxy by id
+		Systems map[int32]SystemInfo
+		Notes   *noteStore
+		Timers  *timerStore
+		// Status is asked for the state of every system drawn, Intel is the same provider when reports can be pushed to it
+		Status StatusProvider
+		Intel  *memoryStatusProvider
 	}
 
 	spyglassMap struct {
+		// ID is the name the map was loaded by
+		ID          string `json:"-"`
 		Name        string `json:"name"`
 		Author      string `json:"author,omitempty"`
 		Description string `json:"description,omitempty"`
+		Version     string `json:"version,omitempty"`
+
+		// Metadata used by the catalog, Regions and Constellations are worked out from the systems when left empty
+		Tags           []string   `json:"tags,omitempty"`
+		Modified       *time.Time `json:"modified,omitempty"`
+		Source         string     `json:"source,omitempty"`
+		License        string     `json:"license,omitempty"`
+		Regions        []int32    `json:"regions,omitempty"`
+		Constellations []int32    `json:"constellations,omitempty"`
 
 		Systems map[int32]spyglassSystem `json:"systems"`
 		Width   int32                    `json:"width"`
 		Height  int32                    `json:"height"`
+
+		// Style holds the map wide defaults for drawing systems, Classes named styles that systems can refer to
+		Style   *spyglassStyle           `json:"style,omitempty"`
+		Classes map[string]spyglassStyle `json:"classes,omitempty"`
+
+		Connections []spyglassConnection `json:"connections,omitempty"`
+		Annotations []spyglassAnnotation `json:"annotations,omitempty"`
+
+		// PointsOfInterest marks systems such as the staging system, see spyglassPOI
+		PointsOfInterest []spyglassPOI `json:"poi,omitempty"`
+
+		// Includes pulls the systems of other maps onto this canvas, see mergeIncludes
+		Includes []spyglassInclude `json:"includes,omitempty"`
+
+		// Conflicts lists the problems found while loading the map, such as overlay changes that no longer apply
+		// or systems missing from New Eden. They are reported but never fatal
+		Conflicts []string `json:"-"`
+	}
+
+	spyglassConnection struct {
+		From int32  `json:"from"`
+		To   int32  `json:"to"`
+		Type string `json:"type,omitempty"`
+	}
+
+	spyglassAnnotation struct {
+		Text string `json:"text"`
+		X    int32  `json:"x"`
+		Y    int32  `json:"y"`
+		Size int32  `json:"size,omitempty"`
+	}
+
+	spyglassInclude struct {
+		Map     string  `json:"map"`
+		Systems []int32 `json:"systems,omitempty"`
+		OffsetX int32   `json:"offset_x,omitempty"`
+		OffsetY int32   `json:"offset_y,omitempty"`
+		Scale   float64 `json:"scale,omitempty"`
 	}
 
 	spyglassSystem struct {
 		ID   int32  `json:"id"`
-		Name string `json:"name"`
+		Name string `json:"name,omitempty"`
 		Icon string `json:"icon,omitempty"`
 		X    int32  `json:"x"`
 		Y    int32  `json:"y"`
 		External bool `json:"external,omitempty"`
+
+		Classes []string       `json:"classes,omitempty"`
+		Style   *spyglassStyle `json:"style,omitempty"`
 	}
 )
 
@@ -51,10 +107,26 @@ func NewEveMapper() *EveMapper {
 		log.Fatal(err)
 	}
 
+	notes, err := loadNotes(notesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	timers, err := loadTimers(timersFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 
+	intel := defaultStatusProvider()
+
 	return &EveMapper{
-		Galaxy: g,
+		Galaxy:  g,
+		Systems: g.IndexSystems(),
+		Notes:   notes,
+		Timers:  timers,
+		Status:  intel,
+		Intel:   intel,
 	}
 }
 
@@ -71,221 +143,582 @@ func (em *EveMapper) ListenAndServe() error {
 	r.Get("/", em.viewIndex)
 	r.Route("/map", func(r chi.Router) {
 		r.Get("/{map}", em.viewMap)
+		r.Get("/{map}/export", em.exportMap)
+		r.Get("/{map}/conflicts", em.viewConflicts)
+		r.Get("/{map}/poi", em.viewPOI)
+	})
+	r.Get("/deployments", em.viewDeployments)
+	r.Get("/themes", em.listThemes)
+	r.Get("/themes/{theme}.css", em.viewStylesheet)
+	r.Route("/datasets", func(r chi.Router) {
+		r.Get("/", em.listDatasetsHandler)
+		r.Get("/{dataset}", em.viewDataset)
+		r.Put("/{dataset}", em.putDataset)
+	})
+	r.Get("/bundle", em.downloadBundle)
+	r.Get("/catalog", em.viewCatalog)
+	r.Route("/notes", func(r chi.Router) {
+		r.Get("/", em.listNotes)
+		r.Post("/", em.addNote)
+		r.Put("/{note}", em.editNote)
+		r.Delete("/{note}", em.deleteNote)
+	})
+	r.Route("/status", func(r chi.Router) {
+		r.Get("/", em.listStatus)
+		r.Post("/", em.reportStatus)
+	})
+	r.Route("/timers", func(r chi.Router) {
+		r.Get("/", em.listTimers)
+		r.Post("/", em.addTimer)
+		r.Delete("/{timer}", em.deleteTimer)
 	})
 
 	return http.ListenAndServe(":8334", r)
 }
 
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Spyglass Maps</title></head>
+<body>
+<form method="get" action="/">
+	<input type="text" name="q" value="{{.Query.Text}}" placeholder="search" />
+	<input type="text" name="tag" value="{{range $i, $t := .Query.Tags}}{{if $i}},{{end}}{{$t}}{{end}}" placeholder="tags" />
+	<input type="text" name="region" value="{{.Query.Region}}" placeholder="region" />
+	<select name="source">
+		<option value="">any source</option>
+		{{range .Sources}}<option value="{{.}}"{{if eq . $.Query.Source}} selected{{end}}>{{.}}</option>{{end}}
+	</select>
+	<input type="submit" value="Filter" />
+</form>
+<table>
+	<tr><th>Map</th><th>Version</th><th>Author</th><th>Source</th><th>Tags</th><th>Systems</th><th>Modified</th><th>Description</th></tr>
+	{{range .Entries}}<tr>
+		<td><a href="/map/{{.Map}}">{{.Name}}</a></td>
+		<td>{{.Version}}</td>
+		<td>{{.Author}}</td>
+		<td>{{.Source}}</td>
+		<td>{{range $i, $t := .Tags}}{{if $i}}, {{end}}<a href="/?tag={{$t}}">{{$t}}</a>{{end}}</td>
+		<td>{{.Systems}}</td>
+		<td>{{.Modified.Format "2006-01-02 15:04"}}</td>
+		<td>{{if .Error}}<b>{{.Error}}</b>{{else}}{{.Description}}{{end}}</td>
+	</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
 func (em *EveMapper) viewIndex(w http.ResponseWriter, r *http.Request) {
 
-	root, err := filepath.Abs("./maps")
-	if err != nil{
+	entries, err := em.Catalog()
+	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	var files []string
+	q := catalogQueryFromRequest(r)
 
-	err = filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
-		name := info.Name()
-		ext := filepath.Ext(name)
-		name = strings.TrimSuffix(name, ext)
-		if name == "maps" {
-			return nil
-		}
-		files = append(files, name)
-		return nil
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(200)
+
+	err = indexTemplate.Execute(w, struct {
+		Query   catalogQuery
+		Sources []string
+		Entries []catalogEntry
+	}{
+		Query:   q,
+		Sources: []string{sourceDotlan, sourceHandMade, sourceAutoLayout},
+		Entries: em.SearchCatalog(entries, q),
 	})
+	if err != nil {
+		log.Println(err)
+	}
+
+}
 
+// viewCatalog serves the catalog as json, filtered the same way as the index page
+func (em *EveMapper) viewCatalog(w http.ResponseWriter, r *http.Request) {
+	entries, err := em.Catalog()
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	w.WriteHeader(200)
+	found := em.SearchCatalog(entries, catalogQueryFromRequest(r))
+	if found == nil {
+		found = []catalogEntry{}
+	}
 
-	for _, f := range files {
-		w.Write([]byte(fmt.Sprintf("<a href=\"/map/%s\">%s</a><br />\n", f, f)))
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(found)
+	if err != nil {
+		log.Println(err)
 	}
+}
+
+func catalogQueryFromRequest(r *http.Request) catalogQuery {
+	v := r.URL.Query()
 
+	var tags []string
+	for _, t := range v["tag"] {
+		for _, tag := range strings.Split(t, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return catalogQuery{
+		Text:    v.Get("q"),
+		Tags:    tags,
+		Source:  v.Get("source"),
+		Author:  v.Get("author"),
+		License: v.Get("license"),
+		Region:  v.Get("region"),
+	}
 }
 
 func (em *EveMapper) viewMap(w http.ResponseWriter, r *http.Request) {
 	mapid := chi.URLParam(r, "map")
-	p, err := filepath.Abs("./maps/" + mapid + ".json")
+
+	m, err := em.LoadMap(mapid)
 	if err != nil {
-		w.WriteHeader(500)
+		w.WriteHeader(mapErrorStatus(err))
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	f, err := os.Open(p)
+	out, err := em.CreateMapSVG(m, renderOptionsFromRequest(r))
 	if err != nil {
-		w.WriteHeader(400)
+		w.WriteHeader(mapErrorStatus(err))
 		w.Write([]byte(err.Error()))
 		return
 	}
-	defer f.Close()
 
-	dec := json.NewDecoder(f)
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, out)
 
-	var m spyglassMap
+}
+
+// viewConflicts lists the problems found while applying the overlay of a map
+func (em *EveMapper) viewConflicts(w http.ResponseWriter, r *http.Request) {
+	mapid := chi.URLParam(r, "map")
 
-	err = dec.Decode(&m)
+	m, err := em.LoadMap(mapid)
 	if err != nil {
-		w.WriteHeader(406)
+		w.WriteHeader(mapErrorStatus(err))
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	out, err := em.CreateMapSVG(m)
+	conflicts := m.Conflicts
+	if conflicts == nil {
+		conflicts = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(conflicts)
 	if err != nil {
-		w.WriteHeader(500)
+		log.Println(err)
+	}
+}
+
+// downloadBundle serves a bundle of the maps given by the map query parameter, or of every map when there are none
+func (em *EveMapper) downloadBundle(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["map"]
+	if len(names) == 0 {
+		var err error
+		names, err = listMaps()
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	// Build the bundle first so that a broken map can still be reported with a proper status
+	var buf bytes.Buffer
+	err := em.WriteBundle(&buf, names)
+	if err != nil {
+		w.WriteHeader(mapErrorStatus(err))
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	fmt.Fprint(w, out)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"spyglass_maps.zip\"")
+	w.Write(buf.Bytes())
+}
+
+// exportMap serves the map as a single flat spyglassMap with all includes resolved
+func (em *EveMapper) exportMap(w http.ResponseWriter, r *http.Request) {
+	mapid := chi.URLParam(r, "map")
 
+	m, err := em.LoadMap(mapid)
+	if err != nil {
+		w.WriteHeader(mapErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(m)
+	if err != nil {
+		log.Println(err)
+	}
 }
 
-func (em *EveMapper) CreateMapSVG(mp spyglassMap) (string, error){
+// renderOptions are the per request choices of how a map is drawn
+type renderOptions struct {
+	// Deployment picks the deployment whose points of interest are shown, empty shows the active ones
+	Deployment string
+	// Theme names the theme the map is drawn in, empty is the default theme
+	Theme string
+	// Timers shows a countdown badge on systems with structure timers
+	Timers bool
+	// Security shades the area around systems by security band, Hulls outlines the constellations
+	Security bool
+	Hulls    bool
+	// SecurityMode colours systems by security status, on the fill or the border, and shows it next to the name
+	SecurityMode string
+	// Edges picks how connections are drawn: straight, curved or orthogonal
+	Edges string
+	// CSS picks inline styles or classes, a Stylesheet url renders with classes and imports the stylesheet
+	// instead of embedding the theme
+	CSS        string
+	Stylesheet string
+	// Origin, Waypoints and Destination give a route to highlight, the waypoints separated by commas.
+	// Systems are given by name or id
+	Origin      string
+	Waypoints   string
+	Destination string
+	// Focus shades every system by its gate jumps from this system, up to FocusRange jumps, along the Gradient
+	// of comma separated colours
+	Focus      string
+	FocusRange int
+	Gradient   string
+	// Heat names a dataset to draw, as colour patches or sized circles depending on HeatMode
+	Heat     string
+	HeatMode string
+}
+
+func renderOptionsFromRequest(r *http.Request) renderOptions {
+	v := r.URL.Query()
+	// A range that is not a number falls back to the default
+	focusRange, _ := strconv.Atoi(v.Get("range"))
+	return renderOptions{
+		Deployment:   v.Get("deployment"),
+		Theme:        v.Get("theme"),
+		Timers:       queryFlag(v.Get("timers")),
+		Security:     queryFlag(v.Get("security")),
+		Hulls:        queryFlag(v.Get("hulls")),
+		SecurityMode: v.Get("sec"),
+		Edges:        v.Get("edges"),
+		CSS:          v.Get("css"),
+		Stylesheet:   v.Get("stylesheet"),
+		Origin:       v.Get("origin"),
+		Waypoints:    v.Get("waypoints"),
+		Destination:  v.Get("destination"),
+		Focus:        v.Get("focus"),
+		FocusRange:   focusRange,
+		Gradient:     v.Get("gradient"),
+		Heat:         v.Get("heat"),
+		HeatMode:     v.Get("heatmode"),
+	}
+}
+
+// queryFlag reads a boolean query parameter, which is set by any value but false or 0
+func queryFlag(v string) bool {
+	return v != "" && v != "0" && v != "false"
+}
+
+func (em *EveMapper) CreateMapSVG(mp spyglassMap, opts renderOptions) (string, error){
 	start := time.Now()
 
-	const systemWidth = 50
-	const systemHeight = 22
-	const systemRounded = 10
+	theme, err := LoadTheme(opts.Theme)
+	if err != nil {
+		return "", err
+	}
 
-	systems := make([]int32, len(mp.Systems))
-	for _, s := range mp.Systems{
-		systems = append(systems, s.ID)
+	switch opts.SecurityMode {
+	case "", secModeFill, secModeBorder:
+	default:
+		return "", fmt.Errorf("security mode '%s' is not '%s' or '%s': %w", opts.SecurityMode, secModeFill, secModeBorder, errRenderOption)
 	}
+	switch opts.Edges {
+	case "", edgesStraight, edgesCurved, edgesOrthogonal:
+	default:
+		return "", fmt.Errorf("edge mode '%s' is not '%s', '%s' or '%s': %w", opts.Edges, edgesStraight, edgesCurved, edgesOrthogonal, errRenderOption)
+	}
+	switch opts.CSS {
+	case "", cssInline, cssClasses:
+	default:
+		return "", fmt.Errorf("css mode '%s' is not '%s' or '%s': %w", opts.CSS, cssInline, cssClasses, errRenderOption)
+	}
+	if opts.Stylesheet != "" {
+		err = checkStylesheet(opts.Stylesheet)
+		if err != nil {
+			return "", err
+		}
+	}
+	classes := opts.CSS == cssClasses || opts.Stylesheet != ""
+	paint := painter{theme: theme, classes: classes}
 
-	// TODO, allow custom connections
-	var connections []string
+	waypoints, err := em.routeWaypoints(opts)
+	if err != nil {
+		return "", err
+	}
+	route, err := em.Route(waypoints)
+	if err != nil {
+		return "", err
+	}
+
+	var heat *heatLayer
+	if opts.Heat != "" {
+		heat, err = em.newHeatLayer(mp, opts, theme)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var focus *focusShading
+	if opts.Focus != "" {
+		focus, err = em.newFocusShading(opts, theme)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The theme sits beneath the map wide style so that maps styling their systems keep their look
+	base := theme.System
+	if mp.Style != nil {
+		base = base.merge(*mp.Style)
+	}
+	mp.Style = &base
+
+	styles := make(map[int32]spyglassStyle, len(mp.Systems))
+	for _, s := range mp.Systems{
+		st, err := mp.SystemStyle(s)
+		if err != nil {
+			return "", err
+		}
+		styles[s.ID] = st
+	}
 
+	points, err := em.PointsOfInterest(mp, opts.Deployment)
+	if err != nil {
+		return "", err
+	}
 
 	var buf bytes.Buffer
 
 	canvas := svg.New(&buf)
 	canvas.Start(int(mp.Width), int(mp.Height))
 
+	// With classes the theme goes into a single stylesheet, so that clients can restyle the map by swapping it
+	background := fmt.Sprintf("fill:%s;stroke:%s;stroke-width:1px", theme.Background, theme.Border)
+	if classes {
+		// A linked stylesheet goes in front of the svg element once the map is done
+		if opts.Stylesheet == "" {
+			canvas.Style("text/css", theme.stylesheet())
+		}
+		background = attr("class", "background")
+	}
+
 	//Draw a border
-	canvas.Rect(0,0,int(mp.Width), int(mp.Height), "fill:rgb(255,255,255);stroke:rgb(0,0,0);stroke-width:1px")
+	canvas.Rect(0,0,int(mp.Width), int(mp.Height), background)
 
-	// First draw all of the connections so that they are beneath all other things. Keep them in their own group
+	// The background layers go beneath everything else
+	if opts.Security {
+		em.drawSecurityShading(canvas, mp, styles, paint)
+	}
+	if opts.Hulls {
+		em.drawConstellationHulls(canvas, mp, styles, paint)
+	}
+	if heat != nil && heat.mode == heatColor {
+		heat.draw(canvas, mp, styles, paint)
+	}
 
-	connections = append(connections, em.GetJumps(systems)...)
+	// First draw all of the connections so that they are beneath all other things. Keep them in their own group
+	// Connections that would pass under other systems can be routed around them
+	conns := em.MapConnections(mp)
+	routes := RouteConnections(opts.Edges, mp, styles, conns)
 
 	canvas.Gid("jumps")
-	for _, con := range connections {
-		sp := strings.Split(con, "-")
-		if len(sp) != 2 {
-			continue
+	for _, c := range conns {
+		attrs := []string{theme.jump(c.Type).style(),
+			attr("data-from", strconv.Itoa(int(c.From))), attr("data-to", strconv.Itoa(int(c.To))), attr("data-type", c.Type)}
+		if classes {
+			attrs[0] = attr("class", "jump "+jumpClass(c.Type))
 		}
 
-		source, sok := strconv.Atoi(sp[0])
-		dest, dok := strconv.Atoi(sp[1])
-		if ((sok != nil) || (dok != nil)) {
-			log.Println(sp[0], sp[1])
-			log.Println("Not ints")
+		r := routes[[2]int32{c.From, c.To}]
+		if r.straight() {
+			canvas.Line(int(r.points[0][0]), int(r.points[0][1]), int(r.points[1][0]), int(r.points[1][1]), attrs...)
 			continue
 		}
-
-		src, srok := mp.Systems[int32(source)]
-		dst, dtok := mp.Systems[int32(dest)]
-		if !(srok || dtok) {
-			log.Println(con)
-			log.Println("not present")
-			continue
+		if !classes {
+			attrs[0] += ";fill:none"
 		}
-		// Get middle point of source system
-		startX := src.X + (systemWidth / 2)
-		startY := src.Y + (systemHeight / 2)
+		canvas.Path(r.path(), attrs...)
+	}
+	canvas.Gend()
 
-		//	Get middle point of destination system
-		endX := dst.X + (systemWidth / 2)
-		endY := dst.Y + (systemHeight / 2)
+	// Circles are bigger than the systems, so they go over the jumps to stay visible
+	if heat != nil && heat.mode == heatCircles {
+		heat.draw(canvas, mp, styles, paint)
+	}
 
-		// TODO implement line colours
-		// TODO investigate use of beziers
-		canvas.Line(int(startX), int(startY), int(endX), int(endY), "stroke:rgb(0,0,0);stroke-width:1px")
+	if len(route) > 0 {
+		drawRoute(canvas, mp, styles, routes, route, paint)
+	}
+
+	em.drawPOIRings(canvas, mp, styles, points, paint)
+
+	notes := em.Notes.BySystem(mp.ID)
+
+	var timers map[int32][]structureTimer
+	if opts.Timers {
+		timers = em.Timers.BySystem()
+	}
+
+	links, err := readLinks()
+	if err != nil {
+		return "", err
 	}
-	canvas.Gend()
 
 	//	Now add all of the systems to the map
-	// Each system is a rounded rect with a height of 30, width of 62, r of 10
+	// Each system is drawn in the shape and size given by its style, by default a rounded rect 50 wide and 22 high
 	canvas.Gid("systems")
-	for _, s := range mp.Systems {
-		// Start an individual group for each system
-		canvas.Gid(strconv.Itoa(int(s.ID)))
-		status := rand.Float32() > 0.5
-		style := "fill:rgb(255,255,255);stroke:rgb(0,0,0);stroke-width:1px"
-		if status {
-			style = "fill:rgb(255,64,64);stroke:rgb(0,0,0);stroke-width:1px"
+	for _, id := range systemIDs(mp.Systems) {
+		s, st := mp.Systems[id], styles[id]
+
+		status := em.Status.Status(s.ID)
+		info, inGalaxy := em.Systems[s.ID]
+
+		// Start an individual group for each system, carrying what clients need to know about it and linking to its details
+		canvas.Group(systemAttrs(s, st, info, inGalaxy, status, links)...)
+		canvas.Title(systemTooltip(s, info, inGalaxy, status, notes[s.ID], start))
+		linked := startSystemLink(canvas, s, info, inGalaxy, links)
+		fill, stroke, width := st.Fill, st.Stroke, st.StrokeWidth
+		statusFill, known := theme.Status[status.State]
+		if known {
+			fill = statusFill
+		}
+		shaded := false
+		if focus != nil && !known {
+			// Intel beats distance, so the focus shading only shows on systems without a report
+			fill, shaded = focus.color(s.ID)
+			if !shaded {
+				fill = st.Fill
+			}
 		}
 
-
-		rnd := systemRounded
-		if s.External {
-			rnd = 0
+		if inGalaxy && opts.SecurityMode != "" {
+			// Systems with intel or focus shading keep that on the fill, so their security goes on the border either way
+			if opts.SecurityMode == secModeFill && !known && !shaded {
+				fill = theme.securityColor(info.SecurityStatus)
+			} else {
+				stroke = theme.securityColor(info.SecurityStatus)
+				if width < 2 {
+					width = 2
+				}
+			}
+		}
+		style := []string{fmt.Sprintf("fill:%s;stroke:%s;stroke-width:%gpx", fill, stroke, width)}
+		if classes {
+			// Only what differs from the theme stays inline, the status fill is left to its class
+			class := "system system--" + status.State
+			if s.External {
+				class += " system--external"
+			}
+			var fillStyle, strokeStyle, widthStyle string
+			if !known && fill != theme.System.Fill {
+				fillStyle = "fill:" + fill
+			}
+			if stroke != theme.System.Stroke {
+				strokeStyle = "stroke:" + stroke
+			}
+			if width != theme.System.StrokeWidth {
+				widthStyle = fmt.Sprintf("stroke-width:%gpx", width)
+			}
+			style = classAttrs(class, fillStyle, strokeStyle, widthStyle)
 		}
 
-		canvas.Roundrect(int(s.X), int(s.Y), systemWidth, systemHeight, systemRounded, rnd, style)
+		drawSystemShape(canvas, s, st, style...)
 
 		//	create the system name text
 		name := s.Name
-		stat := "STATUS!"
-		x := s.X + (systemWidth / 2)
-		yn := s.Y + (systemHeight / 2)
-		ys := s.Y + (systemHeight * 7 / 8)
-
-		canvas.Text(int(x), int(yn), name, "text-anchor:middle;font-size:9px")
-		canvas.Text(int(x), int(ys), stat, "text-anchor:middle;font-size:8px")
+		if st.Label != "" {
+			name = st.Label
+		}
+		if inGalaxy && opts.SecurityMode != "" {
+			name += " " + formatSecurity(info.SecurityStatus)
+		}
+		stat := status.label(start)
+		// External systems are labelled with the region they lead into instead
+		if inGalaxy && s.External {
+			stat = info.Region
+		}
+		x, yn := st.Center(s)
+		ys := s.Y + (st.Height * 7 / 8)
+
+		nameStyle := []string{theme.text(theme.Text, st.FontSize, "text-anchor:middle")}
+		statStyle := []string{theme.text(theme.Text, st.FontSize-1, "text-anchor:middle")}
+		if classes {
+			nameStyle = textAttrs("label-name", st.FontSize, "middle")
+			statStyle = textAttrs("label-status", st.FontSize-1, "middle")
+		}
+		canvas.Text(int(x), int(yn), name, nameStyle...)
+		canvas.Text(int(x), int(ys), stat, statStyle...)
+		if focus != nil {
+			focus.drawJumpCount(canvas, s, st, paint)
+		}
+		if linked {
+			canvas.LinkEnd()
+		}
+		drawNoteIndicator(canvas, s, st, notes[s.ID], paint)
+		drawTimerBadge(canvas, s, st, timers[s.ID], start, paint)
 		canvas.Gend()
 	}
 
 	canvas.Gend()
 
-	canvas.End()
-
-	log.Printf("Generation took %v", time.Since(start))
+	drawPOIMarkers(canvas, mp, points, paint)
 
-	return buf.String(), nil
-}
-
-// GetJumps will return the connections between the monitored systems
-// This list will contain both directions ie 1 -> 2 and 2 -> 1
-func (em *EveMapper) GetJumps(systems []int32) []string {
-	// TODO find a way to preallocate this to some extent
-	jumps := make([]string, 0)
+	if len(route) > 0 {
+		em.drawRouteHops(canvas, mp, styles, route, paint)
+	}
 
-	for _, s := range systems {
-		source, err := em.Galaxy.GetSystem(s)
-		if err != nil {
-			// TODO this shouldnt ever happen so I probably shouldnt be silent here but will do for now
-			continue
+	canvas.Gid("annotations")
+	for _, a := range mp.Annotations {
+		size := a.Size
+		if size == 0 {
+			size = 10
 		}
-
-		for _, gate := range source.Stargates {
-			if em.IsSystemMonitored(gate.Destination.SystemID, systems) {
-				jumps = append(jumps, strconv.Itoa(int(source.SystemID))+"-"+strconv.Itoa(int(gate.Destination.SystemID)))
-			}
+		style := []string{theme.text(theme.Text, size, "")}
+		if classes {
+			style = textAttrs("annotation", size, "")
 		}
+		canvas.Text(int(a.X), int(a.Y), a.Text, style...)
 	}
-	return jumps
-}
+	canvas.Gend()
 
-func (em *EveMapper) IsSystemMonitored(sys int32, syss []int32) bool {
-	for _, s := range syss {
-		if s == sys {
-			return true
-		}
+	if heat != nil {
+		heat.drawLegend(canvas, mp, paint)
 	}
-	return false
-}
 
+	canvas.End()
 
+	log.Printf("Generation took %v", time.Since(start))
 
+	if opts.Stylesheet != "" {
+		return linkStylesheet(buf.String(), opts.Stylesheet), nil
+	}
+	return buf.String(), nil
+}
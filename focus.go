@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+)
+
+const defaultFocusRange = 5
+
+// defaultFocusGradient runs from the focus system itself to the systems furthest out, closest being the most urgent
+var defaultFocusGradient = []string{"rgb(255,60,60)", "rgb(255,200,0)", "rgb(150,210,255)"}
+
+// focusShading colours the systems of a map by their gate jumps from a focus system
+type focusShading struct {
+	dist     map[int32]int
+	limit    int
+	gradient [][3]float64
+}
+
+// newFocusShading measures the jumps from the focus system over all of New Eden, so routes that leave the map count too.
+// The gradient comes from the render options, the theme or the default, in that order.
+func (em *EveMapper) newFocusShading(opts renderOptions, theme mapTheme) (*focusShading, error) {
+	focus, err := em.FindSystem(opts.Focus)
+	if err != nil {
+		return nil, err
+	}
+
+	stops := theme.Focus
+	if opts.Gradient != "" {
+		stops = splitColors(opts.Gradient)
+	}
+	if len(stops) == 0 {
+		stops = defaultFocusGradient
+	}
+
+	// Theme gradients are checked when the theme is loaded, so what is left to fail comes from the request
+	gradient, err := parseGradient(stops)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errRenderOption, err.Error())
+	}
+
+	f := &focusShading{limit: opts.FocusRange, dist: em.JumpDistances(focus, -1), gradient: gradient}
+	if f.limit <= 0 {
+		f.limit = defaultFocusRange
+	}
+	return f, nil
+}
+
+// color returns the shade of a system and whether it is within range of the focus
+func (f *focusShading) color(id int32) (string, bool) {
+	d, ok := f.dist[id]
+	if !ok || d > f.limit {
+		return "", false
+	}
+	return gradientColor(f.gradient, float64(d)/float64(f.limit)), true
+}
+
+// drawJumpCount prints the jumps from the focus system just above the top right corner of a system,
+// clear of its name and of the markers that line up from the top left
+func (f *focusShading) drawJumpCount(canvas *svg.SVG, s spyglassSystem, st spyglassStyle, p painter) {
+	d, ok := f.dist[s.ID]
+	if !ok {
+		return
+	}
+	canvas.Text(int(s.X+st.Width), int(s.Y)-2, strconv.Itoa(d)+"j", p.text("focus-count", p.theme.Text, 7, "end", "font-weight:bold")...)
+}
+
+// gradientColor interpolates between evenly spaced colour stops, t running from 0 to 1
+func gradientColor(stops [][3]float64, t float64) string {
+	if len(stops) == 1 || t <= 0 {
+		return rgbString(stops[0])
+	}
+	if t >= 1 {
+		return rgbString(stops[len(stops)-1])
+	}
+
+	pos := t * float64(len(stops)-1)
+	i := int(pos)
+	f := pos - float64(i)
+	a, b := stops[i], stops[i+1]
+	return rgbString([3]float64{a[0] + (b[0]-a[0])*f, a[1] + (b[1]-a[1])*f, a[2] + (b[2]-a[2])*f})
+}
+
+// parseGradient reads the colour stops of a gradient
+func parseGradient(stops []string) ([][3]float64, error) {
+	gradient := make([][3]float64, 0, len(stops))
+	for _, c := range stops {
+		rgb, err := parseColor(c)
+		if err != nil {
+			return nil, err
+		}
+		gradient = append(gradient, rgb)
+	}
+	return gradient, nil
+}
+
+func rgbString(c [3]float64) string {
+	return fmt.Sprintf("rgb(%.0f,%.0f,%.0f)", c[0], c[1], c[2])
+}
+
+// parseColor reads a colour written as rgb(r,g,b) or #rrggbb
+func parseColor(c string) ([3]float64, error) {
+	var rgb [3]float64
+	c = strings.TrimSpace(c)
+
+	switch {
+	case strings.HasPrefix(c, "#") && len(c) == 7:
+		for i := range rgb {
+			v, err := strconv.ParseUint(c[1+2*i:3+2*i], 16, 8)
+			if err != nil {
+				return rgb, fmt.Errorf("invalid colour '%s': %w", c, err)
+			}
+			rgb[i] = float64(v)
+		}
+		return rgb, nil
+	case strings.HasPrefix(c, "rgb(") && strings.HasSuffix(c, ")"):
+		parts := strings.Split(c[4:len(c)-1], ",")
+		if len(parts) != 3 {
+			break
+		}
+		for i, p := range parts {
+			v, err := strconv.ParseUint(strings.TrimSpace(p), 10, 8)
+			if err != nil {
+				return rgb, fmt.Errorf("invalid colour '%s': %w", c, err)
+			}
+			rgb[i] = float64(v)
+		}
+		return rgb, nil
+	}
+	return rgb, fmt.Errorf("invalid colour '%s', use rgb(r,g,b) or #rrggbb", c)
+}
+
+// splitColors splits a comma separated list of colours, leaving the commas inside rgb() alone
+func splitColors(list string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, r := range list {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, list[start:])
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		color string
+		want  [3]float64
+		ok    bool
+	}{
+		{"#ff8000", [3]float64{255, 128, 0}, true},
+		{"#FFffFF", [3]float64{255, 255, 255}, true},
+		{"rgb(1,2,3)", [3]float64{1, 2, 3}, true},
+		{" rgb( 10 , 20 , 30 ) ", [3]float64{10, 20, 30}, true},
+		{"#fff", [3]float64{}, false},
+		{"#gg0000", [3]float64{}, false},
+		{"rgb(256,0,0)", [3]float64{}, false},
+		{"rgb(1,2)", [3]float64{}, false},
+		{"rgba(1,2,3,4)", [3]float64{}, false},
+		{"red", [3]float64{}, false},
+		{"", [3]float64{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.color, func(t *testing.T) {
+			got, err := parseColor(tt.color)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseColor(%q) error = %v, want ok %v", tt.color, err, tt.ok)
+			}
+			if tt.ok && got != tt.want {
+				t.Errorf("parseColor(%q) = %v, want %v", tt.color, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitColors(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"#ff0000", []string{"#ff0000"}},
+		{"#ff0000,#00ff00", []string{"#ff0000", "#00ff00"}},
+		{"rgb(1,2,3),#00ff00,rgb(4,5,6)", []string{"rgb(1,2,3)", "#00ff00", "rgb(4,5,6)"}},
+		{"", []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got := splitColors(tt.list)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitColors(%q) = %q, want %q", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGradient(t *testing.T) {
+	got, err := parseGradient([]string{"#000000", "rgb(255,255,255)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][3]float64{{0, 0, 0}, {255, 255, 255}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGradient = %v, want %v", got, want)
+	}
+
+	_, err = parseGradient([]string{"#000000", "white"})
+	if err == nil {
+		t.Error("parseGradient accepted an invalid stop")
+	}
+}
+
+func TestGradientColor(t *testing.T) {
+	stops := [][3]float64{{0, 0, 0}, {200, 100, 0}, {200, 200, 200}}
+	tests := []struct {
+		name  string
+		stops [][3]float64
+		t     float64
+		want  string
+	}{
+		{"start", stops, 0, "rgb(0,0,0)"},
+		{"before the start", stops, -1, "rgb(0,0,0)"},
+		{"between the first stops", stops, 0.25, "rgb(100,50,0)"},
+		{"middle stop", stops, 0.5, "rgb(200,100,0)"},
+		{"between the last stops", stops, 0.75, "rgb(200,150,100)"},
+		{"end", stops, 1, "rgb(200,200,200)"},
+		{"past the end", stops, 2, "rgb(200,200,200)"},
+		{"single stop", stops[1:2], 0.5, "rgb(200,100,0)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gradientColor(tt.stops, tt.t); got != tt.want {
+				t.Errorf("gradientColor(%v) = %s, want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
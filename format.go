@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// mapExtensions are the file types a map or overlay can be stored as, in order of preference
+var mapExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// All formats are read into a yaml.Node, which keeps both the order of the keys and any comments.
+// Loading a map turns the node into json so that the json tags on the map types are the only ones needed.
+
+// parseMapDocument reads the raw contents of a map file in the format given by its extension
+func parseMapDocument(data []byte, ext string) (*yaml.Node, error) {
+	switch ext {
+	case ".json", ".yaml", ".yml":
+		// json is valid yaml, so both take the same route
+		var doc yaml.Node
+		err := yaml.Unmarshal(data, &doc)
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			return nil, errors.New("empty document")
+		}
+		return doc.Content[0], nil
+	case ".toml":
+		var raw map[string]interface{}
+		md, err := toml.Decode(string(data), &raw)
+		if err != nil {
+			return nil, err
+		}
+		order := make(map[string]int)
+		for i, k := range md.Keys() {
+			if _, ok := order[k.String()]; !ok {
+				order[k.String()] = i
+			}
+		}
+		return tomlToNode(raw, "", order), nil
+	}
+	return nil, fmt.Errorf("unsupported map format '%s'", ext)
+}
+
+// writeMapDocument renders doc in the format given by ext
+func writeMapDocument(doc *yaml.Node, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch ext {
+	case ".json":
+		err := writeJSONNode(&buf, doc, "")
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+	case ".yaml", ".yml":
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err := enc.Encode(doc)
+		if err != nil {
+			return nil, err
+		}
+		enc.Close()
+	case ".toml":
+		if resolveAlias(doc).Kind != yaml.MappingNode {
+			return nil, errors.New("toml documents must be a table at the top level")
+		}
+		err := writeTOMLTable(&buf, resolveAlias(doc), nil)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported map format '%s'", ext)
+	}
+	return buf.Bytes(), nil
+}
+
+// checkFileName refuses names of maps and other files that would lead outside their directory,
+// they are treated as not existing
+func checkFileName(kind, name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid %s name '%s': %w", kind, name, fs.ErrNotExist)
+	}
+	return nil
+}
+
+// findMapFile returns the path of dir/name in the first of the supported formats that exists
+func findMapFile(dir, name string) (string, error) {
+	err := checkFileName("map", name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, ext := range mapExtensions {
+		p, err := filepath.Abs(filepath.Join(dir, name+ext))
+		if err != nil {
+			return "", err
+		}
+		_, err = os.Stat(p)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("map '%s': %w", name, fs.ErrNotExist)
+}
+
+// decodeMapFile decodes the file at p into dest, whatever its format
+func decodeMapFile(p string, dest interface{}) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	doc, err := parseMapDocument(data, filepath.Ext(p))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = writeJSONNode(&buf, doc, "")
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(buf.Bytes(), dest)
+}
+
+// ConvertMapFile rewrites the map file at src as dst, the formats are taken from the file extensions
+func ConvertMapFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	doc, err := parseMapDocument(data, filepath.Ext(src))
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", src, err)
+	}
+
+	if filepath.Ext(src) == ".json" {
+		blockStyle(doc)
+	}
+
+	out, err := writeMapDocument(doc, filepath.Ext(dst))
+	if err != nil {
+		return fmt.Errorf("failed to write '%s': %w", dst, err)
+	}
+
+	return os.WriteFile(dst, out, 0644)
+}
+
+// patchMapFile writes m to dest as the document of the map file at src, where old is what src decodes to.
+// Only the parts of the document that differ between old and m are replaced, so comments and the order of
+// the keys survive everywhere else.
+func patchMapFile(src, dest string, old, m spyglassMap) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	doc, err := parseMapDocument(data, filepath.Ext(src))
+	if err != nil {
+		return err
+	}
+	if doc.Kind != yaml.MappingNode {
+		return fmt.Errorf("'%s' is not a map file", filepath.Base(src))
+	}
+
+	before, err := valueNode(old)
+	if err != nil {
+		return err
+	}
+	after, err := valueNode(m)
+	if err != nil {
+		return err
+	}
+	doc = patchNode(doc, before, after)
+
+	if filepath.Ext(src) == ".json" {
+		blockStyle(doc)
+	}
+	out, err := writeMapDocument(doc, filepath.Ext(dest))
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dest, out, 0644)
+}
+
+// valueNode turns v into a node the way it would be written to a map file
+func valueNode(v interface{}) (*yaml.Node, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := parseMapDocument(data, ".json")
+	if err != nil {
+		return nil, err
+	}
+	blockStyle(n)
+
+	return n, nil
+}
+
+// patchNode changes doc from before into after. Mappings are patched key by key, sequences keep the items
+// that are still there, anything else that changed is replaced as a whole.
+func patchNode(doc, before, after *yaml.Node) *yaml.Node {
+	if equalNodes(before, after) {
+		return doc
+	}
+
+	doc = resolveAlias(doc)
+	if doc.Kind == yaml.SequenceNode && before.Kind == yaml.SequenceNode && after.Kind == yaml.SequenceNode &&
+		len(doc.Content) == len(before.Content) {
+		return patchSequence(doc, before, after)
+	}
+	if doc.Kind != yaml.MappingNode || before.Kind != yaml.MappingNode || after.Kind != yaml.MappingNode {
+		after.HeadComment, after.LineComment, after.FootComment = doc.HeadComment, doc.LineComment, doc.FootComment
+		return after
+	}
+
+	for i := 0; i+1 < len(before.Content); i += 2 {
+		if nodeValue(after, before.Content[i].Value) == nil {
+			deleteNodeKey(doc, before.Content[i].Value)
+		}
+	}
+	for i := 0; i+1 < len(after.Content); i += 2 {
+		key, value := after.Content[i].Value, after.Content[i+1]
+		j := nodeIndex(doc, key)
+		switch {
+		case j < 0:
+			// Keys that were filled in on reading, like the ids of systems, stay left out
+			if old := nodeValue(before, key); old == nil || !equalNodes(old, value) {
+				// json keys are always strings, let the encoder decide whether system ids need quotes
+				after.Content[i].Tag = ""
+				doc.Content = append(doc.Content, after.Content[i], value)
+			}
+		case nodeValue(before, key) == nil:
+			doc.Content[j+1] = value
+		default:
+			doc.Content[j+1] = patchNode(doc.Content[j+1], nodeValue(before, key), value)
+		}
+	}
+
+	return doc
+}
+
+// patchSequence changes the items of doc from before into after. Items found unchanged in after keep their
+// node from doc, with its comments, the others are taken from after.
+func patchSequence(doc, before, after *yaml.Node) *yaml.Node {
+	used := make([]bool, len(before.Content))
+	items := make([]*yaml.Node, 0, len(after.Content))
+	for _, item := range after.Content {
+		kept := item
+		for i, old := range before.Content {
+			if !used[i] && equalNodes(old, item) {
+				used[i] = true
+				kept = doc.Content[i]
+				break
+			}
+		}
+		items = append(items, kept)
+	}
+	doc.Content = items
+	return doc
+}
+
+// equalNodes compares the contents of two nodes, ignoring their style and comments
+func equalNodes(a, b *yaml.Node) bool {
+	a, b = resolveAlias(a), resolveAlias(b)
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !equalNodes(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeIndex returns the index of key in a mapping node, or -1
+func nodeIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func deleteNodeKey(n *yaml.Node, key string) {
+	i := nodeIndex(n, key)
+	if i >= 0 {
+		n.Content = append(n.Content[:i], n.Content[i+2:]...)
+	}
+}
+
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+// blockStyle clears the flow style that json documents are parsed with so they are written as regular yaml
+func blockStyle(n *yaml.Node) {
+	n.Style &^= yaml.FlowStyle
+	if n.Kind == yaml.ScalarNode && n.Style&yaml.DoubleQuotedStyle != 0 {
+		// The encoder quotes again whatever would not read back as a string
+		n.Style &^= yaml.DoubleQuotedStyle
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+func writeJSONNode(buf *bytes.Buffer, n *yaml.Node, indent string) error {
+	n = resolveAlias(n)
+	inner := indent + "\t"
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeJSONNode(buf, n.Content[0], indent)
+	case yaml.MappingNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteString(",\n")
+			}
+			key, err := json.Marshal(resolveAlias(n.Content[i]).Value)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(inner)
+			buf.Write(key)
+			buf.WriteString(": ")
+			err = writeJSONNode(buf, n.Content[i+1], inner)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + indent + "}")
+	case yaml.SequenceNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, c := range n.Content {
+			if i > 0 {
+				buf.WriteString(",\n")
+			}
+			buf.WriteString(inner)
+			err := writeJSONNode(buf, c, inner)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + indent + "]")
+	case yaml.ScalarNode:
+		var v interface{}
+		err := n.Decode(&v)
+		if err != nil {
+			return err
+		}
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339)
+		}
+		out, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		buf.Write(out)
+	}
+	return nil
+}
+
+// tomlToNode builds a node from decoded toml, ordering the keys of every table as they appeared in the file
+func tomlToNode(v interface{}, path string, order map[string]int) *yaml.Node {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		pos := func(k string) int {
+			p, ok := order[strings.TrimPrefix(path+"."+k, ".")]
+			if !ok {
+				return len(order)
+			}
+			return p
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			pi, pj := pos(keys[i]), pos(keys[j])
+			if pi != pj {
+				return pi < pj
+			}
+			return keys[i] < keys[j]
+		})
+
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, k := range keys {
+			n.Content = append(n.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k},
+				tomlToNode(val[k], strings.TrimPrefix(path+"."+k, "."), order))
+		}
+		return n
+	case []map[string]interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, c := range val {
+			n.Content = append(n.Content, tomlToNode(c, path, order))
+		}
+		return n
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, c := range val {
+			n.Content = append(n.Content, tomlToNode(c, path, order))
+		}
+		return n
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: val}
+	case int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(val, 10)}
+	case float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(val, 'g', -1, 64)}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(val)}
+	case time.Time:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: val.Format(time.RFC3339)}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(v)}
+}
+
+// writeTOMLTable writes the plain values of a table first and its sub tables after, as toml requires.
+// Comments on keys are kept, nulls are dropped as toml has no way to express them.
+func writeTOMLTable(buf *bytes.Buffer, n *yaml.Node, path []string) error {
+	type entry struct {
+		key   *yaml.Node
+		value *yaml.Node
+	}
+	var tables []entry
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := resolveAlias(n.Content[i]), resolveAlias(n.Content[i+1])
+		if value.Kind == yaml.MappingNode || isTableArray(value) {
+			tables = append(tables, entry{key, value})
+			continue
+		}
+		if value.Tag == "!!null" {
+			continue
+		}
+
+		writeTOMLComment(buf, key.HeadComment)
+		buf.WriteString(tomlKey(key.Value) + " = ")
+		err := writeTOMLValue(buf, value)
+		if err != nil {
+			return err
+		}
+		if key.LineComment != "" {
+			buf.WriteString(" " + key.LineComment)
+		} else if value.LineComment != "" {
+			buf.WriteString(" " + value.LineComment)
+		}
+		buf.WriteString("\n")
+	}
+
+	for _, t := range tables {
+		sub := append(append([]string{}, path...), tomlKey(t.key.Value))
+		if t.value.Kind == yaml.MappingNode {
+			buf.WriteString("\n")
+			writeTOMLComment(buf, t.key.HeadComment)
+			buf.WriteString("[" + strings.Join(sub, ".") + "]\n")
+			err := writeTOMLTable(buf, t.value, sub)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for i, el := range t.value.Content {
+			buf.WriteString("\n")
+			if i == 0 {
+				writeTOMLComment(buf, t.key.HeadComment)
+			}
+			buf.WriteString("[[" + strings.Join(sub, ".") + "]]\n")
+			err := writeTOMLTable(buf, resolveAlias(el), sub)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeTOMLValue(buf *bytes.Buffer, n *yaml.Node) error {
+	n = resolveAlias(n)
+	switch n.Kind {
+	case yaml.SequenceNode:
+		buf.WriteString("[")
+		for i, c := range n.Content {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			err := writeTOMLValue(buf, c)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	case yaml.MappingNode:
+		buf.WriteString("{")
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(tomlKey(resolveAlias(n.Content[i]).Value) + " = ")
+			err := writeTOMLValue(buf, n.Content[i+1])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString("}")
+	case yaml.ScalarNode:
+		var v interface{}
+		err := n.Decode(&v)
+		if err != nil {
+			return err
+		}
+		switch val := v.(type) {
+		case string:
+			buf.WriteString(strconv.Quote(val))
+		case nil:
+			buf.WriteString(`""`)
+		case time.Time:
+			buf.WriteString(val.Format(time.RFC3339))
+		default:
+			buf.WriteString(fmt.Sprint(val))
+		}
+	}
+	return nil
+}
+
+// isTableArray reports whether n is a non empty list of tables, which toml writes as [[name]] sections
+func isTableArray(n *yaml.Node) bool {
+	if n.Kind != yaml.SequenceNode || len(n.Content) == 0 {
+		return false
+	}
+	for _, c := range n.Content {
+		if resolveAlias(c).Kind != yaml.MappingNode {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlKey(k string) string {
+	if k == "" {
+		return `""`
+	}
+	for _, r := range k {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return strconv.Quote(k)
+		}
+	}
+	return k
+}
+
+func writeTOMLComment(buf *bytes.Buffer, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			line = "# " + line
+		}
+		buf.WriteString(line + "\n")
+	}
+}
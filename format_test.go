@@ -0,0 +1,266 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const formatMapJSON = `{
+	"name": "Test",
+	"author": "someone",
+	"width": 400,
+	"height": 200,
+	"tags": ["pvp", "staging"],
+	"style": {"fill": "rgb(255,255,255)", "stroke_width": 1.5},
+	"systems": {
+		"1": {"name": "Alpha", "x": 10, "y": 10, "classes": ["hub"]},
+		"2": {"name": "Bravo", "x": 100, "y": 10, "external": true},
+		"30000142": {"name": "Jita", "x": 200, "y": 50}
+	},
+	"connections": [
+		{"from": 1, "to": 2, "type": "bridge"},
+		{"from": 2, "to": 30000142}
+	],
+	"annotations": [{"text": "north \"edge\"", "x": 5, "y": 5, "size": 12}]
+}
+`
+
+func TestConvertMapFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain []string
+	}{
+		{"json to yaml and back", []string{".json", ".yaml", ".json"}},
+		{"json to toml and back", []string{".json", ".toml", ".json"}},
+		{"through every format", []string{".json", ".yaml", ".toml", ".json"}},
+		{"yml to toml to yaml", []string{".json", ".yml", ".toml", ".yaml"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "map0.json")
+			err := os.WriteFile(src, []byte(formatMapJSON), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := src
+			for i, ext := range tt.chain[1:] {
+				dst := filepath.Join(dir, "map"+string(rune('1'+i))+ext)
+				err = ConvertMapFile(p, dst)
+				if err != nil {
+					data, _ := os.ReadFile(p)
+					t.Fatalf("convert %s to %s: %v\n%s", filepath.Base(p), ext, err, data)
+				}
+				p = dst
+			}
+
+			var want, got interface{}
+			err = decodeMapFile(src, &want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = decodeMapFile(p, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				data, _ := os.ReadFile(p)
+				t.Errorf("converted map differs from the input:\n%s", data)
+			}
+
+			var m spyglassMap
+			err = decodeMapFile(p, &m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Systems) != 3 || m.Systems[30000142].Name != "Jita" || !m.Systems[2].External {
+				t.Errorf("converted map decodes to systems %v", m.Systems)
+			}
+		})
+	}
+}
+
+func TestConvertMapFileKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "map.json")
+	err := os.WriteFile(src, []byte(formatMapJSON), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ext := range []string{".yaml", ".toml"} {
+		t.Run(ext, func(t *testing.T) {
+			dst := filepath.Join(dir, "map"+ext)
+			err := ConvertMapFile(src, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertInOrder(t, string(data), "name", "author", "width", "height", "Alpha", "Bravo", "Jita")
+		})
+	}
+}
+
+func TestWriteMapDocumentKeepsComments(t *testing.T) {
+	const doc = `# Staging map
+name: Test # shown in the catalog
+systems:
+  # the hub
+  1:
+    name: Alpha
+    x: 10
+    y: 10
+  2:
+    name: Bravo
+    x: 100
+    y: 10
+`
+	n, err := parseMapDocument([]byte(doc), ".yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ext  string
+		want []string
+	}{
+		{".yaml", []string{"# Staging map", "# shown in the catalog", "# the hub"}},
+		{".toml", []string{"# Staging map", "# shown in the catalog", "# the hub"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ext, func(t *testing.T) {
+			out, err := writeMapDocument(n, tt.ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ext == ".yaml" && string(out) != doc {
+				t.Errorf("yaml did not round trip:\n%s", out)
+			}
+			for _, c := range tt.want {
+				if !strings.Contains(string(out), c) {
+					t.Errorf("%s output lacks comment %q:\n%s", tt.ext, c, out)
+				}
+			}
+		})
+	}
+}
+
+func TestPatchMapFileKeepsCommentsAndOrder(t *testing.T) {
+	const doc = `# Staging map
+name: Test
+author: someone # keep me
+width: 400
+systems:
+  # the hub
+  2:
+    name: Bravo
+    x: 100
+    y: 10
+  1:
+    name: Alpha # first
+    x: 10
+    y: 10
+connections:
+  - from: 1
+    to: 2 # gate
+`
+	tests := []struct {
+		name   string
+		change func(m *spyglassMap)
+		has    []string
+		lacks  []string
+		order  []string
+	}{
+		{
+			name:   "map field",
+			change: func(m *spyglassMap) { m.Width = 500 },
+			has:    []string{"width: 500\n"},
+			order:  []string{"# Staging map", "name: Test", "author: someone # keep me", "width: 500", "# the hub", "Bravo", "Alpha # first"},
+		},
+		{
+			name: "moved system",
+			change: func(m *spyglassMap) {
+				s := m.Systems[1]
+				s.X = 20
+				m.Systems[1] = s
+			},
+			has:   []string{"    x: 20\n"},
+			order: []string{"# the hub", "2:", "Bravo", "1:", "Alpha # first", "x: 20", "to: 2 # gate"},
+		},
+		{
+			name:   "removed system",
+			change: func(m *spyglassMap) { delete(m.Systems, 2) },
+			lacks:  []string{"Bravo"},
+			order:  []string{"# Staging map", "author: someone # keep me", "Alpha # first", "to: 2 # gate"},
+		},
+		{
+			name: "added system and connection",
+			change: func(m *spyglassMap) {
+				m.Systems[3] = spyglassSystem{ID: 3, Name: "Charlie", X: 200, Y: 10}
+				m.Connections = append(m.Connections, spyglassConnection{From: 2, To: 3})
+			},
+			order: []string{"# the hub", "Bravo", "Alpha # first", "Charlie", "to: 2 # gate", "from: 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "Test.yaml")
+			err := os.WriteFile(p, []byte(doc), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old, err := readRawMap(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, _ := MergeMaps(old, old, old)
+			tt.change(&m)
+
+			err = patchMapFile(p, p, old, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+
+			for _, s := range tt.has {
+				if !strings.Contains(out, s) {
+					t.Errorf("patched file lacks %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.lacks {
+				if strings.Contains(out, s) {
+					t.Errorf("patched file still has %q:\n%s", s, out)
+				}
+			}
+			assertInOrder(t, out, tt.order...)
+		})
+	}
+}
+
+// assertInOrder fails unless every part is found in s after the one before it
+func assertInOrder(t *testing.T, s string, parts ...string) {
+	t.Helper()
+	rest := s
+	for _, p := range parts {
+		i := strings.Index(rest, p)
+		if i < 0 {
+			t.Errorf("%q missing or out of order in:\n%s", p, s)
+			return
+		}
+		rest = rest[i+len(p):]
+	}
+}
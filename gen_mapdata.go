@@ -121,13 +121,15 @@ type (
 		Description string `json:"description,omitempty"`
 		Width   int32                    `json:"width"`
 		Height  int32                    `json:"height"`
+		Source  string                   `json:"source,omitempty"`
+		Regions []int32                  `json:"regions,omitempty"`
 
 		Systems map[int32]spyglassSystem `json:"systems"`
 	}
 
 	spyglassSystem struct {
 		ID   int32  `json:"id"`
-		Name string `json:"name"`
+		Name string `json:"name,omitempty"`
 		Icon string `json:"icon,omitempty"`
 		X    int32  `json:"x"`
 		Y    int32  `json:"y"`
@@ -433,10 +435,12 @@ func main() {
 		log.Println("\tGrabbing map " + dotlanMap)
 
 		description := ""
+		var regions []int32
 
 		for _, region := range ne {
-			if region.Name == dotlanMap {
+			if region.Name == strings.ReplaceAll(dotlanMap, "_", " ") {
 				description = region.Description
+				regions = []int32{region.RegionID}
 				break
 			}
 		}
@@ -448,6 +452,8 @@ func main() {
 			Name:        dotlanMap,
 			Author:      "Dotlan",
 			Description: description,
+			Source:      "dotlan",
+			Regions:     regions,
 		}
 
 		url := fmt.Sprintf(urlDotlanMap, dotlanMap)
@@ -477,10 +483,11 @@ func main() {
 			systemRect := doc.Find("rect", "id", "rect" + strconv.Itoa(i))
 			external := strings.HasPrefix(systemRect.Attrs()["class"], "e")
 
+			// Names are filled in from New Eden when the map is loaded, one that cannot be found is left out
 			name := ""
 			evesys, err := ne.GetSystem(int32(i))
 			if err != nil {
-				name = strconv.Itoa(i)
+				log.Printf("WARN: map %s has unknown system %d", dotlanMap, i)
 			} else {
 				name = evesys.Name
 			}
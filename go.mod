@@ -3,7 +3,9 @@ module spyglass_mapper
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/ajstarks/svgo v0.0.0-20200725142600-7a3c8b57fecb
 	github.com/anaskhan96/soup v1.2.4 // indirect
 	github.com/go-chi/chi v1.5.3
+	gopkg.in/yaml.v3 v3.0.1
 )
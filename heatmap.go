@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/go-chi/chi"
+)
+
+const (
+	datasetsDir = "./datasets"
+
+	// Ways of drawing a dataset: a colour patch behind each system or a circle sized by its value
+	heatColor   = "color"
+	heatCircles = "circles"
+
+	// heatRadius is the radius of the circle of the highest value
+	heatRadius = 28.0
+	// legendSteps is the number of values shown in the legend
+	legendSteps = 5
+)
+
+// legendCircles are the values shown in the legend of circles, as a share of the highest value
+var legendCircles = []float64{1, 0.25}
+
+// datasetExtensions are the formats of the dataset files, tried in order
+var datasetExtensions = []string{".json", ".csv"}
+
+// defaultHeatGradient runs from the lowest to the highest value
+var defaultHeatGradient = []string{"rgb(255,255,180)", "rgb(255,150,0)", "rgb(200,0,0)"}
+
+// The themed look of heat patches and circles, whose colour comes from their value
+const (
+	heatPatchStyle  = "fill-opacity:0.75;stroke:none"
+	heatCircleStyle = "fill-opacity:0.55;stroke:none"
+)
+
+// dataset holds a number per system, such as kills or jumps in the last hour
+type dataset map[int32]float64
+
+// findDatasetFile returns the path of the named dataset
+func findDatasetFile(name string) (string, error) {
+	err := checkFileName("dataset", name)
+	if err != nil {
+		return "", err
+	}
+	for _, ext := range datasetExtensions {
+		p := filepath.Join(datasetsDir, name+ext)
+		_, err := os.Stat(p)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("dataset '%s': %w", name, fs.ErrNotExist)
+}
+
+// LoadDataset reads the named dataset from the datasets dir
+func (em *EveMapper) LoadDataset(name string) (dataset, error) {
+	p, err := findDatasetFile(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := em.parseDataset(data, filepath.Ext(p))
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %s", errMapDecode, filepath.Base(p), err.Error())
+	}
+	return ds, nil
+}
+
+// parseDataset reads a dataset as csv, rows of system and value with an optional header, or as json,
+// an object of system to value or a list of objects with a system and a value. Systems are given by id or name.
+func (em *EveMapper) parseDataset(data []byte, ext string) (dataset, error) {
+	ds := make(dataset)
+
+	if ext == ".csv" {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		for line := 1; ; line++ {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(rec) < 2 {
+				return nil, fmt.Errorf("line %d: need a system and a value", line)
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+			if err != nil {
+				if line == 1 {
+					// A header
+					continue
+				}
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			id, err := em.FindSystem(rec[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			ds[id] += v
+		}
+		return ds, nil
+	}
+
+	var byKey map[string]float64
+	if err := json.Unmarshal(data, &byKey); err == nil {
+		for ref, v := range byKey {
+			id, err := em.FindSystem(ref)
+			if err != nil {
+				return nil, err
+			}
+			ds[id] += v
+		}
+		return ds, nil
+	}
+
+	var rows []struct {
+		System json.RawMessage `json:"system"`
+		Value  float64         `json:"value"`
+	}
+	err := json.Unmarshal(data, &rows)
+	if err != nil {
+		return nil, errors.New("expected an object of system to value or a list of {\"system\", \"value\"}")
+	}
+	for _, row := range rows {
+		ref := strings.Trim(string(row.System), `"`)
+		id, err := em.FindSystem(ref)
+		if err != nil {
+			return nil, err
+		}
+		ds[id] += row.Value
+	}
+	return ds, nil
+}
+
+// writeDataset stores a dataset as json, replacing any other file of that name
+func writeDataset(name string, ds dataset) error {
+	tmp, err := stageDataset(name, ds)
+	if err != nil {
+		return err
+	}
+	return commitDataset(name, tmp)
+}
+
+// stageDataset writes a dataset to a temporary file in the datasets dir and returns its path
+func stageDataset(name string, ds dataset) (string, error) {
+	err := checkFileName("dataset", name)
+	if err != nil {
+		return "", err
+	}
+
+	out := make(map[string]float64, len(ds))
+	for id, v := range ds {
+		out[strconv.Itoa(int(id))] = v
+	}
+	data, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(datasetsDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	return writeTemp(filepath.Join(datasetsDir, name+".json"), data)
+}
+
+// commitDataset moves a staged dataset into place. Files of the same name in other formats are only removed
+// afterwards, so the dataset can be read at any time.
+func commitDataset(name, tmp string) error {
+	err := os.Rename(tmp, filepath.Join(datasetsDir, name+".json"))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	for _, ext := range datasetExtensions {
+		if ext == ".json" {
+			continue
+		}
+		err = os.Remove(filepath.Join(datasetsDir, name+ext))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDatasets returns the names of the datasets, sorted
+func listDatasets() ([]string, error) {
+	entries, err := os.ReadDir(datasetsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	names := []string{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".json" && ext != ".csv") {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ext)
+		if !found[name] {
+			found[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// IngestESI turns a saved response of ESI's /universe/system_kills/ or /universe/system_jumps/ into datasets,
+// one for each number it holds such as ship_kills, npc_kills, pod_kills and ship_jumps. A prefix is put before their names.
+func IngestESI(p, prefix string) ([]string, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]interface{}
+	err = json.Unmarshal(data, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': not an ESI system list: %s", errMapDecode, filepath.Base(p), err.Error())
+	}
+
+	sets := make(map[string]dataset)
+	for i, row := range rows {
+		sys, ok := row["system_id"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w '%s': entry %d has no system_id", errMapDecode, filepath.Base(p), i)
+		}
+		for key, v := range row {
+			n, ok := v.(float64)
+			if !ok || key == "system_id" {
+				continue
+			}
+			if sets[key] == nil {
+				sets[key] = make(dataset)
+			}
+			sets[key][int32(sys)] = n
+		}
+	}
+
+	keys := make([]string, 0, len(sets))
+	for key := range sets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Every dataset is written before any of them replaces the old one, so a failure leaves all of them as they were
+	staged := make([]string, 0, len(keys))
+	for _, key := range keys {
+		tmp, err := stageDataset(prefix+key, sets[key])
+		if err != nil {
+			for _, t := range staged {
+				os.Remove(t)
+			}
+			return nil, err
+		}
+		staged = append(staged, tmp)
+	}
+
+	names := make([]string, 0, len(keys))
+	for i, key := range keys {
+		err = commitDataset(prefix+key, staged[i])
+		if err != nil {
+			for _, t := range staged[i+1:] {
+				os.Remove(t)
+			}
+			return names, err
+		}
+		names = append(names, prefix+key)
+	}
+	return names, nil
+}
+
+// heatLayer draws a dataset on a map, scaled to the highest value among the systems of the map
+type heatLayer struct {
+	name     string
+	data     dataset
+	max      float64
+	mode     string
+	gradient [][3]float64
+}
+
+func (em *EveMapper) newHeatLayer(mp spyglassMap, opts renderOptions, theme mapTheme) (*heatLayer, error) {
+	switch opts.HeatMode {
+	case "", heatColor, heatCircles:
+	default:
+		return nil, fmt.Errorf("heat mode '%s' is not '%s' or '%s': %w", opts.HeatMode, heatColor, heatCircles, errRenderOption)
+	}
+
+	ds, err := em.LoadDataset(opts.Heat)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &heatLayer{name: opts.Heat, data: ds, mode: opts.HeatMode}
+	if h.mode == "" {
+		h.mode = heatColor
+	}
+	for id := range mp.Systems {
+		h.max = math.Max(h.max, ds[id])
+	}
+
+	stops := theme.Heat
+	if len(stops) == 0 {
+		stops = defaultHeatGradient
+	}
+	// Theme gradients are checked when the theme is loaded
+	h.gradient, err = parseGradient(stops)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// scale returns where a value lies between zero and the highest value
+func (h *heatLayer) scale(v float64) float64 {
+	if h.max <= 0 {
+		return 0
+	}
+	return v / h.max
+}
+
+// draw puts a patch or circle for every system with a value above zero, beneath the systems.
+// Their colour depends on the value, so it stays inline with classes.
+func (h *heatLayer) draw(canvas *svg.SVG, mp spyglassMap, styles map[int32]spyglassStyle, p painter) {
+	canvas.Gid("heat")
+	for _, id := range systemIDs(mp.Systems) {
+		v := h.data[id]
+		if v <= 0 {
+			continue
+		}
+		s, st := mp.Systems[id], styles[id]
+		t := h.scale(v)
+		color := gradientColor(h.gradient, t)
+
+		if h.mode == heatCircles {
+			x, y := st.Center(s)
+			r := 4 + math.Sqrt(t)*heatRadius
+			canvas.Circle(int(x), int(y), int(r), append(p.attrs("heat-circle", heatCircleStyle, "fill:"+color), attr("data-value", formatValue(v)))...)
+			continue
+		}
+		canvas.Roundrect(int(s.X)-10, int(s.Y)-10, int(st.Width)+20, int(st.Height)+20, 14, 14,
+			append(p.attrs("heat-patch", heatPatchStyle, "fill:"+color), attr("data-value", formatValue(v)))...)
+	}
+	canvas.Gend()
+}
+
+// drawLegend explains the colours or circle sizes in the bottom left corner of the map, in a box sized to fit
+func (h *heatLayer) drawLegend(canvas *svg.SVG, mp spyglassMap, p painter) {
+	width := int(textWidth(h.name, 9)) + 8
+	var height int
+	var radii []int
+	if h.mode == heatCircles {
+		// Circles sit side by side, their values below the largest of them
+		cx := 6
+		for _, t := range legendCircles {
+			r := int(4 + math.Sqrt(t)*heatRadius)
+			radii = append(radii, r)
+			label := int(textWidth(formatValue(t*h.max), 8))
+			cx += maxInt(2*r, label) + 6
+		}
+		width = maxInt(width, cx)
+		height = 18 + 2*radii[0] + 14
+	} else {
+		width = maxInt(width, 24+int(textWidth(formatValue(h.max), 8))+6)
+		height = 16 + legendSteps*14
+	}
+	x, y := 4, int(mp.Height)-height-4
+
+	canvas.Gid("legend")
+	canvas.Rect(x, y, width, height, p.attrs("legend", p.theme.legendStyle())...)
+	canvas.Text(x+4, y+11, h.name, p.text("legend-title", p.theme.Text, 9, "", "font-weight:bold")...)
+
+	if h.mode == heatCircles {
+		cy := y + 18 + radii[0]
+		cx := x + 6
+		for i, t := range legendCircles {
+			r := radii[i]
+			slot := maxInt(2*r, int(textWidth(formatValue(t*h.max), 8)))
+			mid := cx + slot/2
+			canvas.Circle(mid, cy, r, p.attrs("heat-circle", heatCircleStyle, "fill:"+gradientColor(h.gradient, t))...)
+			canvas.Text(mid, cy+radii[0]+11, formatValue(t*h.max), p.text("legend-label", p.theme.Text, 8, "middle", "")...)
+			cx += slot + 6
+		}
+		canvas.Gend()
+		return
+	}
+
+	for i := 0; i < legendSteps; i++ {
+		t := float64(legendSteps-1-i) / float64(legendSteps-1)
+		sy := y + 16 + i*14
+		canvas.Rect(x+4, sy, 16, 11, p.attrs("legend-swatch", "stroke:none", "fill:"+gradientColor(h.gradient, t))...)
+		canvas.Text(x+24, sy+9, formatValue(t*h.max), p.text("legend-label", p.theme.Text, 8, "", "")...)
+	}
+	canvas.Gend()
+}
+
+func (t mapTheme) legendStyle() string {
+	return fmt.Sprintf("fill:%s;fill-opacity:0.85;stroke:%s;stroke-width:0.5px", t.Background, t.Border)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// formatValue writes whole numbers without decimals
+func formatValue(v float64) string {
+	if v == math.Trunc(v) {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+func (em *EveMapper) listDatasetsHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := listDatasets()
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, 200, names)
+}
+
+func (em *EveMapper) viewDataset(w http.ResponseWriter, r *http.Request) {
+	ds, err := em.LoadDataset(chi.URLParam(r, "dataset"))
+	if err != nil {
+		w.WriteHeader(mapErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, 200, ds)
+}
+
+// putDataset stores a dataset sent as json, or as csv when the content type says so
+func (em *EveMapper) putDataset(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	ext := ".json"
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		ext = ".csv"
+	}
+	ds, err := em.parseDataset(data, ext)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = writeDataset(chi.URLParam(r, "dataset"), ds)
+	if err != nil {
+		w.WriteHeader(mapErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, 200, ds)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// inTempDir runs the rest of a test in an empty working directory, as datasets live relative to it
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestParseDataset(t *testing.T) {
+	em := testMapper()
+	tests := []struct {
+		name string
+		ext  string
+		data string
+		want dataset
+		ok   bool
+	}{
+		{"csv", ".csv", "1,5\n2,3.5\n", dataset{1: 5, 2: 3.5}, true},
+		{"csv with header and names", ".csv", "system,kills\nAlpha, 4\n golf ,2\n", dataset{1: 4, 7: 2}, true},
+		{"csv sums duplicates", ".csv", "Alpha,1\n1,2\n", dataset{1: 3}, true},
+		{"csv bad value", ".csv", "1,5\n2,many\n", nil, false},
+		{"csv unknown system", ".csv", "Zulu,5\n", nil, false},
+		{"csv missing value", ".csv", "1\n", nil, false},
+		{"json object", ".json", `{"1": 2, "Delta": 7}`, dataset{1: 2, 4: 7}, true},
+		{"json list", ".json", `[{"system": 3, "value": 1}, {"system": "Charlie", "value": 2}]`, dataset{3: 3}, true},
+		{"json unknown system", ".json", `{"Zulu": 1}`, nil, false},
+		{"json of another shape", ".json", `"kills"`, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := em.parseDataset([]byte(tt.data), tt.ext)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseDataset error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDataset = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDataset(t *testing.T) {
+	inTempDir(t)
+	em := testMapper()
+
+	err := os.MkdirAll(datasetsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(datasetsDir, "kills.csv"), []byte("1,1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeDataset("kills", dataset{2: 4, 3: 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(datasetsDir, "kills.csv"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("older csv of the dataset was kept: %v", err)
+	}
+	got, err := em.LoadDataset("kills")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (dataset{2: 4, 3: 1.5}); !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %v, want %v", got, want)
+	}
+
+	names, err := listDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kills"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("datasets = %v, want %v (no temporary files)", names, want)
+	}
+
+	for _, name := range []string{"", "../kills", "a/b"} {
+		err = writeDataset(name, dataset{1: 1})
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("writeDataset(%q) = %v, want an invalid name", name, err)
+		}
+	}
+}
+
+func TestIngestESI(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		prefix string
+		names  []string
+		sets   map[string]dataset
+		err    error
+	}{
+		{
+			name:   "kills",
+			data:   `[{"system_id": 1, "ship_kills": 3, "npc_kills": 10, "pod_kills": 0}, {"system_id": 4, "ship_kills": 1, "npc_kills": 0, "pod_kills": 1}]`,
+			prefix: "esi_",
+			names:  []string{"esi_npc_kills", "esi_pod_kills", "esi_ship_kills"},
+			sets: map[string]dataset{
+				"esi_npc_kills":  {1: 10, 4: 0},
+				"esi_pod_kills":  {1: 0, 4: 1},
+				"esi_ship_kills": {1: 3, 4: 1},
+			},
+		},
+		{
+			name:  "jumps",
+			data:  `[{"system_id": 2, "ship_jumps": 42}]`,
+			names: []string{"ship_jumps"},
+			sets:  map[string]dataset{"ship_jumps": {2: 42}},
+		},
+		{
+			name: "empty",
+			data: `[]`,
+		},
+		{
+			name: "not a list",
+			data: `{"system_id": 1}`,
+			err:  errMapDecode,
+		},
+		{
+			name: "entry without a system",
+			data: `[{"ship_jumps": 42}]`,
+			err:  errMapDecode,
+		},
+		{
+			name:   "invalid prefix",
+			data:   `[{"system_id": 2, "ship_jumps": 42}]`,
+			prefix: "../",
+			err:    fs.ErrNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := inTempDir(t)
+			em := testMapper()
+			p := filepath.Join(dir, "esi.json")
+			err := os.WriteFile(p, []byte(tt.data), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names, err := IngestESI(p, tt.prefix)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("IngestESI error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if len(names) != len(tt.names) || (len(names) > 0 && !reflect.DeepEqual(names, tt.names)) {
+				t.Errorf("names = %v, want %v", names, tt.names)
+			}
+			for name, want := range tt.sets {
+				got, err := em.LoadDataset(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("dataset %s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	svg "github.com/ajstarks/svgo"
+)
+
+const (
+	// hullPadding is the room left between a constellation hull and the systems in it
+	hullPadding = 10
+	// shadePadding is how far the security shading reaches around a system, enough for neighbours to blend into an area
+	shadePadding = 16
+)
+
+// drawSecurityShading draws a soft patch in the colour of its security band behind every known system
+func (em *EveMapper) drawSecurityShading(canvas *svg.SVG, mp spyglassMap, styles map[int32]spyglassStyle, p painter) {
+	canvas.Gid("security")
+	for _, id := range systemIDs(mp.Systems) {
+		info, ok := em.Systems[id]
+		if !ok {
+			continue
+		}
+		s, st := mp.Systems[id], styles[id]
+		band := securityBand(info.SecurityStatus)
+		canvas.Roundrect(int(s.X)-shadePadding, int(s.Y)-shadePadding, int(st.Width)+2*shadePadding, int(st.Height)+2*shadePadding,
+			shadePadding, shadePadding, p.attrs("security security--"+band, p.theme.shadeStyle(band))...)
+	}
+	canvas.Gend()
+}
+
+func (t mapTheme) shadeStyle(band string) string {
+	return fmt.Sprintf("fill:%s;fill-opacity:0.12;stroke:none", t.Security[band])
+}
+
+// drawConstellationHulls draws the convex hull around the systems of each constellation with its name above it.
+// External systems are left out since they only stand for where the gates lead.
+func (em *EveMapper) drawConstellationHulls(canvas *svg.SVG, mp spyglassMap, styles map[int32]spyglassStyle, p painter) {
+	members := make(map[int32][]int32)
+	for _, id := range systemIDs(mp.Systems) {
+		info, ok := em.Systems[id]
+		if !ok || mp.Systems[id].External {
+			continue
+		}
+		members[info.ConstellationID] = append(members[info.ConstellationID], id)
+	}
+
+	cids := make([]int32, 0, len(members))
+	for cid := range members {
+		cids = append(cids, cid)
+	}
+	sort.Slice(cids, func(i, j int) bool { return cids[i] < cids[j] })
+
+	canvas.Gid("constellations")
+	for _, cid := range cids {
+		var points [][2]int
+		for _, id := range members[cid] {
+			s, st := mp.Systems[id], styles[id]
+			x0, y0 := int(s.X)-hullPadding, int(s.Y)-hullPadding
+			x1, y1 := int(s.X+st.Width)+hullPadding, int(s.Y+st.Height)+hullPadding
+			points = append(points, [2]int{x0, y0}, [2]int{x1, y0}, [2]int{x1, y1}, [2]int{x0, y1})
+		}
+
+		hull := convexHull(points)
+		xs, ys := make([]int, len(hull)), make([]int, len(hull))
+		top, left := hull[0][1], hull[0][0]
+		for i, p := range hull {
+			xs[i], ys[i] = p[0], p[1]
+			if p[1] < top || (p[1] == top && p[0] < left) {
+				top, left = p[1], p[0]
+			}
+		}
+
+		canvas.Polygon(xs, ys, p.attrs("hull", p.theme.hullStyle())...)
+		// The name goes in the padding along the top of the hull
+		canvas.Text(left+2, top+8, em.Systems[members[cid][0]].Constellation,
+			p.text("label-constellation", p.theme.MutedText, 9, "", "font-style:italic")...)
+	}
+	canvas.Gend()
+}
+
+func (t mapTheme) hullStyle() string {
+	return fmt.Sprintf("fill:%s;fill-opacity:0.08;stroke:%s;stroke-opacity:0.4;stroke-width:1px;stroke-linejoin:round", t.Hull, t.Hull)
+}
+
+// convexHull returns the corners of the convex hull of points in counter clockwise order, using the monotone chain algorithm
+func convexHull(points [][2]int) [][2]int {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i][0] != points[j][0] {
+			return points[i][0] < points[j][0]
+		}
+		return points[i][1] < points[j][1]
+	})
+	if len(points) < 3 {
+		return points
+	}
+
+	cross := func(o, a, b [2]int) int {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+
+	hull := make([][2]int, 0, 2*len(points))
+	for _, p := range points {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- {
+		p := points[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
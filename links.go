@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	svg "github.com/ajstarks/svgo"
+)
+
+const linksFile = "./links.json"
+
+// systemLink is a url template for the detail pages of a system. The placeholders {id}, {name}, {constellation}
+// and {region} are replaced by those of the system, names with spaces written with underscores like dotlan does.
+type systemLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// defaultLinks are used when there is no links file
+var defaultLinks = []systemLink{
+	{Name: "dotlan", URL: "https://evemaps.dotlan.net/system/{name}"},
+	{Name: "zkillboard", URL: "https://zkillboard.com/system/{id}/"},
+}
+
+// readLinks reads the link templates from the links file, the first one is opened by clicking a system
+func readLinks() ([]systemLink, error) {
+	data, err := os.ReadFile(linksFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaultLinks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var links []systemLink
+	err = json.Unmarshal(data, &links)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode links: %w", err)
+	}
+	return links, nil
+}
+
+// expand fills in the template for a system
+func (l systemLink) expand(id int32, info SystemInfo) string {
+	name := func(s string) string {
+		return url.PathEscape(strings.ReplaceAll(s, " ", "_"))
+	}
+	return strings.NewReplacer(
+		"{id}", strconv.Itoa(int(id)),
+		"{name}", name(info.Name),
+		"{constellation}", name(info.Constellation),
+		"{region}", name(info.Region),
+	).Replace(l.URL)
+}
+
+// attr formats an attribute for the extra arguments of the svg elements, which pass it on as it is
+func attr(name, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, html.EscapeString(value))
+}
+
+// systemAttrs are the data attributes of a system group, enough for clients to hit-test and look up the system
+func systemAttrs(s spyglassSystem, st spyglassStyle, info SystemInfo, inGalaxy bool, status SystemStatus, links []systemLink) []string {
+	attrs := []string{
+		attr("id", strconv.Itoa(int(s.ID))),
+		attr("data-id", strconv.Itoa(int(s.ID))),
+		attr("data-name", s.Name),
+		attr("data-x", strconv.Itoa(int(s.X))),
+		attr("data-y", strconv.Itoa(int(s.Y))),
+		attr("data-width", strconv.Itoa(int(st.Width))),
+		attr("data-height", strconv.Itoa(int(st.Height))),
+		attr("data-status", status.State),
+	}
+	if !status.LastReport.IsZero() {
+		attrs = append(attrs, attr("data-last-report", status.LastReport.UTC().Format(time.RFC3339)))
+	}
+	if s.External {
+		attrs = append(attrs, attr("data-external", "true"))
+	}
+	if !inGalaxy {
+		return attrs
+	}
+
+	attrs = append(attrs,
+		attr("data-security", formatSecurity(info.SecurityStatus)),
+		attr("data-constellation", info.Constellation),
+		attr("data-region", info.Region),
+	)
+	for _, l := range links {
+		attrs = append(attrs, attr("data-link-"+l.Name, l.expand(s.ID, info)))
+	}
+	return attrs
+}
+
+// systemTooltip is the text shown when hovering a system
+func systemTooltip(s spyglassSystem, info SystemInfo, inGalaxy bool, status SystemStatus, notes []systemNote, now time.Time) string {
+	lines := []string{s.Name}
+	if inGalaxy {
+		lines[0] = fmt.Sprintf("%s (%s)", info.Name, formatSecurity(info.SecurityStatus))
+		lines = append(lines, fmt.Sprintf("%s, %s", info.Constellation, info.Region))
+	}
+
+	switch {
+	case !status.LastReport.IsZero():
+		intel := fmt.Sprintf("Intel: %s, %s ago", status.State, now.Sub(status.LastReport).Round(time.Minute))
+		if status.Text != "" {
+			intel += ": " + status.Text
+		}
+		lines = append(lines, intel)
+	case status.State != statusUnknown:
+		lines = append(lines, "Intel: "+status.label(now))
+	default:
+		lines = append(lines, "No intel")
+	}
+
+	for _, n := range notes {
+		lines = append(lines, "Note: "+n.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// startSystemLink wraps a system in a link to its first url template, it returns whether it did
+func startSystemLink(canvas *svg.SVG, s spyglassSystem, info SystemInfo, inGalaxy bool, links []systemLink) bool {
+	if !inGalaxy || len(links) == 0 {
+		return false
+	}
+	canvas.Link(html.EscapeString(links[0].expand(s.ID, info)), info.Name)
+	return true
+}
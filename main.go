@@ -1,9 +1,20 @@
 package main
 
-import "log"
+import (
+	"log"
+	"os"
+)
 
 func main() {
 
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	em := NewEveMapper()
 	err := em.ListenAndServe()
 	if err != nil {
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	mapsDir     = "./maps"
+	overlaysDir = "./overlays"
+)
+
+var (
+	errMapDecode = errors.New("invalid map file")
+	// errRenderOption is returned for render options that make no sense, such as an unknown mode
+	errRenderOption = errors.New("invalid render option")
+)
+
+// LoadMap reads the named map from the maps directory and resolves everything it refers to,
+// the returned map is flat and ready to be rendered
+func (em *EveMapper) LoadMap(name string) (spyglassMap, error) {
+	m, err := em.loadMap(name, nil)
+	if err != nil {
+		return m, err
+	}
+
+	m.ID = name
+	em.resolveNames(&m)
+
+	// A map without a size is sized to its content
+	if m.Width == 0 || m.Height == 0 {
+		err = m.Fit(defaultMargin, 0)
+		if err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+func (em *EveMapper) loadMap(name string, seen []string) (spyglassMap, error) {
+	for _, s := range seen {
+		if s == name {
+			return spyglassMap{}, fmt.Errorf("map '%s' includes itself: %s", name, strings.Join(append(seen, name), " -> "))
+		}
+	}
+
+	ov, err := readOverlayFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return em.loadMapFile(name, seen)
+	}
+	if err != nil {
+		return spyglassMap{}, err
+	}
+
+	// An overlay may share its name with the map it patches, that base must then come straight from the maps dir
+	var m spyglassMap
+	if ov.Base == name {
+		m, err = em.loadMapFile(ov.Base, append(seen, name))
+	} else {
+		m, err = em.loadMap(ov.Base, append(seen, name))
+	}
+	if err != nil {
+		return m, fmt.Errorf("failed to load base map '%s' of overlay '%s': %w", ov.Base, name, err)
+	}
+
+	m = applyOverlay(m, ov)
+	for _, c := range m.Conflicts {
+		log.Printf("WARN: overlay %s: %s", name, c)
+	}
+
+	return m, nil
+}
+
+// loadMapFile loads a map from the maps dir, ignoring any overlay of the same name
+func (em *EveMapper) loadMapFile(name string, seen []string) (spyglassMap, error) {
+	m, err := readMapFile(name)
+	if err != nil {
+		return m, err
+	}
+
+	if len(m.Includes) > 0 {
+		m, err = em.mergeIncludes(m, append(seen, name))
+		if err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+// listMaps returns the names of all maps and overlays that can be passed to LoadMap
+func listMaps() ([]string, error) {
+	return listMapFiles(mapsDir, overlaysDir)
+}
+
+// listMapFiles returns the names of the files in any map format found in dirs, sorted and without duplicates
+func listMapFiles(dirs ...string) ([]string, error) {
+	found := make(map[string]bool)
+	var names []string
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			ext := filepath.Ext(e.Name())
+			if e.IsDir() || !isMapExtension(ext) {
+				continue
+			}
+			name := strings.TrimSuffix(e.Name(), ext)
+			if !found[name] {
+				found[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// readMapFile decodes a single map file without resolving any of its references
+func readMapFile(name string) (spyglassMap, error) {
+	var m spyglassMap
+
+	err := readMapData(mapsDir, name, &m)
+	if err != nil {
+		return m, err
+	}
+
+	fillSystemIDs(&m)
+
+	return m, nil
+}
+
+// readRawMap decodes the map file at p as it is on disk, without resolving its includes, overlays or names
+func readRawMap(p string) (spyglassMap, error) {
+	var m spyglassMap
+	err := decodeMapFile(p, &m)
+	if err != nil {
+		return m, fmt.Errorf("%w '%s': %s", errMapDecode, filepath.Base(p), err.Error())
+	}
+
+	fillSystemIDs(&m)
+
+	return m, nil
+}
+
+// checkPlainMap refuses files at p that are not maps themselves, like overlays which only describe changes to one
+func checkPlainMap(p string) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	doc, err := parseMapDocument(data, filepath.Ext(p))
+	if err != nil {
+		return fmt.Errorf("%w '%s': %s", errMapDecode, filepath.Base(p), err.Error())
+	}
+	if doc.Kind != yaml.MappingNode {
+		return fmt.Errorf("%w '%s': not a map", errMapDecode, filepath.Base(p))
+	}
+	if nodeValue(doc, "base") != nil {
+		return fmt.Errorf("'%s' is an overlay, not a map", filepath.Base(p))
+	}
+	return nil
+}
+
+// fillSystemIDs sets the id of systems that left it out, map files may as it is already the key
+func fillSystemIDs(m *spyglassMap) {
+	if m.Systems == nil {
+		m.Systems = make(map[int32]spyglassSystem)
+	}
+
+	for id, s := range m.Systems {
+		if s.ID == 0 {
+			s.ID = id
+			m.Systems[id] = s
+		}
+	}
+}
+
+func isMapExtension(ext string) bool {
+	for _, e := range mapExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// readMapData decodes the map file dir/name into dest, whichever of the supported formats it is stored in
+func readMapData(dir, name string, dest interface{}) error {
+	p, err := findMapFile(dir, name)
+	if err != nil {
+		return err
+	}
+
+	err = decodeMapFile(p, dest)
+	if err != nil {
+		return fmt.Errorf("%w '%s': %s", errMapDecode, filepath.Base(p), err.Error())
+	}
+
+	return nil
+}
+
+// mapErrorStatus picks the http status to report for an error returned by LoadMap
+func mapErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, errRenderOption):
+		return 400
+	case errors.Is(err, errMapDecode):
+		return 406
+	}
+	return 500
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeMoved    = "moved"
+	changeRestyled = "restyled"
+	changeModified = "modified"
+)
+
+type (
+	// mapDiff lists the changes that turn one map into another
+	mapDiff struct {
+		Fields      []string
+		Systems     []systemChange
+		Connections []connectionChange
+		Annotations []annotationChange
+		Points      []poiChange
+	}
+
+	systemChange struct {
+		ID    int32
+		Kinds []string
+		Old   spyglassSystem
+		New   spyglassSystem
+	}
+
+	connectionChange struct {
+		Kind       string
+		Connection spyglassConnection
+	}
+
+	annotationChange struct {
+		Kind       string
+		Annotation spyglassAnnotation
+	}
+
+	poiChange struct {
+		Kind  string
+		Point spyglassPOI
+	}
+
+	// mergeConflict is a change made differently on both sides of a merge, System is 0 for map wide fields
+	mergeConflict struct {
+		System int32
+		Field  string
+	}
+)
+
+func (c systemChange) String() string {
+	switch c.Kinds[0] {
+	case changeAdded:
+		return fmt.Sprintf("%s added at %d,%d", systemLabel(c.ID, c.New), c.New.X, c.New.Y)
+	case changeRemoved:
+		return fmt.Sprintf("%s removed", systemLabel(c.ID, c.Old))
+	}
+
+	out := systemLabel(c.ID, c.New)
+	for _, k := range c.Kinds {
+		if k == changeMoved {
+			out += fmt.Sprintf(" moved from %d,%d to %d,%d", c.Old.X, c.Old.Y, c.New.X, c.New.Y)
+		} else {
+			out += " " + k
+		}
+	}
+	return out
+}
+
+func systemLabel(id int32, s spyglassSystem) string {
+	if s.Name == "" {
+		return fmt.Sprintf("system %d", id)
+	}
+	return fmt.Sprintf("system %d (%s)", id, s.Name)
+}
+
+func (c connectionChange) String() string {
+	return fmt.Sprintf("connection %d-%d %s", c.Connection.From, c.Connection.To, c.Kind)
+}
+
+func (c annotationChange) String() string {
+	return fmt.Sprintf("annotation '%s' at %d,%d %s", c.Annotation.Text, c.Annotation.X, c.Annotation.Y, c.Kind)
+}
+
+func (c poiChange) String() string {
+	out := fmt.Sprintf("%s on system %d", c.Point.Kind, c.Point.System)
+	if c.Point.Label != "" {
+		out = fmt.Sprintf("%s '%s' on system %d", c.Point.Kind, c.Point.Label, c.Point.System)
+	}
+	return fmt.Sprintf("point of interest %s %s", out, c.Kind)
+}
+
+func (c mergeConflict) String() string {
+	if c.System == 0 {
+		return fmt.Sprintf("map %s changed on both sides", c.Field)
+	}
+	return fmt.Sprintf("system %d %s changed on both sides", c.System, c.Field)
+}
+
+// Lines returns every change in d, one per line
+func (d mapDiff) Lines() []string {
+	var lines []string
+	for _, f := range d.Fields {
+		lines = append(lines, fmt.Sprintf("map %s changed", f))
+	}
+	for _, c := range d.Systems {
+		lines = append(lines, c.String())
+	}
+	for _, c := range d.Connections {
+		lines = append(lines, c.String())
+	}
+	for _, c := range d.Annotations {
+		lines = append(lines, c.String())
+	}
+	for _, c := range d.Points {
+		lines = append(lines, c.String())
+	}
+	return lines
+}
+
+// mapFields are the map wide values that are compared and merged as a whole, by their json name
+var mapFields = []string{
+	"name", "author", "description", "version", "tags", "modified", "source", "license",
+	"regions", "constellations", "width", "height", "style", "includes",
+}
+
+// mapField returns a pointer to the map wide value named by one of mapFields
+func mapField(m *spyglassMap, name string) interface{} {
+	switch name {
+	case "name":
+		return &m.Name
+	case "author":
+		return &m.Author
+	case "description":
+		return &m.Description
+	case "version":
+		return &m.Version
+	case "tags":
+		return &m.Tags
+	case "modified":
+		return &m.Modified
+	case "source":
+		return &m.Source
+	case "license":
+		return &m.License
+	case "regions":
+		return &m.Regions
+	case "constellations":
+		return &m.Constellations
+	case "width":
+		return &m.Width
+	case "height":
+		return &m.Height
+	case "style":
+		return &m.Style
+	case "includes":
+		return &m.Includes
+	}
+	panic("unknown map field " + name)
+}
+
+// systemAspects split a system into the parts that are diffed and merged on their own,
+// so that one side moving a system and the other restyling it is not a conflict
+var systemAspects = []string{changeMoved, changeRestyled, changeModified}
+
+func systemAspect(s spyglassSystem, aspect string) interface{} {
+	switch aspect {
+	case changeMoved:
+		return [2]int32{s.X, s.Y}
+	case changeRestyled:
+		return struct {
+			Classes []string
+			Style   *spyglassStyle
+		}{s.Classes, s.Style}
+	}
+	return struct {
+		Name     string
+		Icon     string
+		External bool
+	}{s.Name, s.Icon, s.External}
+}
+
+func setSystemAspect(s *spyglassSystem, from spyglassSystem, aspect string) {
+	switch aspect {
+	case changeMoved:
+		s.X, s.Y = from.X, from.Y
+	case changeRestyled:
+		s.Classes, s.Style = from.Classes, from.Style
+	default:
+		s.Name, s.Icon, s.External = from.Name, from.Icon, from.External
+	}
+}
+
+// DiffMaps lists what changed from a to b
+func DiffMaps(a, b spyglassMap) mapDiff {
+	var d mapDiff
+
+	for _, f := range mapFields {
+		if !reflect.DeepEqual(mapField(&a, f), mapField(&b, f)) {
+			d.Fields = append(d.Fields, f)
+		}
+	}
+	if !reflect.DeepEqual(a.Classes, b.Classes) {
+		d.Fields = append(d.Fields, "classes")
+	}
+
+	for _, id := range systemIDs(a.Systems, b.Systems) {
+		old, inA := a.Systems[id]
+		cur, inB := b.Systems[id]
+		switch {
+		case !inA:
+			d.Systems = append(d.Systems, systemChange{ID: id, Kinds: []string{changeAdded}, New: cur})
+		case !inB:
+			d.Systems = append(d.Systems, systemChange{ID: id, Kinds: []string{changeRemoved}, Old: old})
+		default:
+			c := systemChange{ID: id, Old: old, New: cur}
+			for _, aspect := range systemAspects {
+				if !reflect.DeepEqual(systemAspect(old, aspect), systemAspect(cur, aspect)) {
+					c.Kinds = append(c.Kinds, aspect)
+				}
+			}
+			if len(c.Kinds) > 0 {
+				d.Systems = append(d.Systems, c)
+			}
+		}
+	}
+
+	inA, inB := connectionSet(a.Connections), connectionSet(b.Connections)
+	for _, c := range a.Connections {
+		if !inB[connectionKey(c)] {
+			d.Connections = append(d.Connections, connectionChange{Kind: changeRemoved, Connection: c})
+		}
+	}
+	for _, c := range b.Connections {
+		if !inA[connectionKey(c)] {
+			d.Connections = append(d.Connections, connectionChange{Kind: changeAdded, Connection: c})
+		}
+	}
+
+	annA, annB := annotationSet(a.Annotations), annotationSet(b.Annotations)
+	for _, an := range a.Annotations {
+		if !annB[an] {
+			d.Annotations = append(d.Annotations, annotationChange{Kind: changeRemoved, Annotation: an})
+		}
+	}
+	for _, an := range b.Annotations {
+		if !annA[an] {
+			d.Annotations = append(d.Annotations, annotationChange{Kind: changeAdded, Annotation: an})
+		}
+	}
+
+	poiA, poiB := poiSet(a.PointsOfInterest), poiSet(b.PointsOfInterest)
+	for _, p := range a.PointsOfInterest {
+		if !poiB[p] {
+			d.Points = append(d.Points, poiChange{Kind: changeRemoved, Point: p})
+		}
+	}
+	for _, p := range b.PointsOfInterest {
+		if !poiA[p] {
+			d.Points = append(d.Points, poiChange{Kind: changeAdded, Point: p})
+		}
+	}
+
+	return d
+}
+
+// MergeMaps combines the changes made to base in ours and theirs. Changes to different systems, or to different
+// aspects of the same system, are merged, as are added and removed connections, annotations and points of interest.
+// Where both sides changed the same thing differently ours is kept and the conflict is returned.
+func MergeMaps(base, ours, theirs spyglassMap) (spyglassMap, []mergeConflict) {
+	merged := ours
+	var conflicts []mergeConflict
+
+	for _, f := range mapFields {
+		v, ok := merge3(mapField(&base, f), mapField(&ours, f), mapField(&theirs, f))
+		if !ok {
+			conflicts = append(conflicts, mergeConflict{Field: f})
+			continue
+		}
+		reflect.ValueOf(mapField(&merged, f)).Elem().Set(reflect.ValueOf(v).Elem())
+	}
+
+	merged.Classes = make(map[string]spyglassStyle)
+	for _, name := range classNames(base.Classes, ours.Classes, theirs.Classes) {
+		b, bok := base.Classes[name]
+		o, ook := ours.Classes[name]
+		t, tok := theirs.Classes[name]
+		v, ok := merge3(optional(b, bok), optional(o, ook), optional(t, tok))
+		if !ok {
+			conflicts = append(conflicts, mergeConflict{Field: "class " + name})
+			v = optional(o, ook)
+		}
+		if st := v.(*spyglassStyle); st != nil {
+			merged.Classes[name] = *st
+		}
+	}
+	if len(merged.Classes) == 0 {
+		merged.Classes = nil
+	}
+
+	merged.Systems = make(map[int32]spyglassSystem)
+	for _, id := range systemIDs(base.Systems, ours.Systems, theirs.Systems) {
+		b, inBase := base.Systems[id]
+		o, inOurs := ours.Systems[id]
+		t, inTheirs := theirs.Systems[id]
+
+		switch {
+		case !inBase:
+			// Added on one or both sides, both adding the same system differently is a conflict
+			if inOurs && inTheirs && !reflect.DeepEqual(o, t) {
+				conflicts = append(conflicts, mergeConflict{System: id, Field: "addition"})
+			}
+			if inOurs {
+				merged.Systems[id] = o
+			} else {
+				merged.Systems[id] = t
+			}
+		case !inOurs && !inTheirs:
+		case !inOurs || !inTheirs:
+			// Removed on one side, which only stands if the other side left the system alone
+			kept := o
+			if !inOurs {
+				kept = t
+			}
+			if !reflect.DeepEqual(b, kept) {
+				conflicts = append(conflicts, mergeConflict{System: id, Field: "removal"})
+				if inOurs {
+					merged.Systems[id] = o
+				}
+			}
+		default:
+			s := o
+			for _, aspect := range systemAspects {
+				ba, oa, ta := systemAspect(b, aspect), systemAspect(o, aspect), systemAspect(t, aspect)
+				switch {
+				case reflect.DeepEqual(oa, ta), !reflect.DeepEqual(ba, ta) && reflect.DeepEqual(ba, oa):
+					setSystemAspect(&s, t, aspect)
+				case reflect.DeepEqual(ba, ta):
+				default:
+					conflicts = append(conflicts, mergeConflict{System: id, Field: aspectField(aspect)})
+				}
+			}
+			merged.Systems[id] = s
+		}
+	}
+
+	merged.Connections = mergeConnections(base.Connections, ours.Connections, theirs.Connections)
+	merged.Annotations = mergeAnnotations(base.Annotations, ours.Annotations, theirs.Annotations)
+	merged.PointsOfInterest = mergePOIs(base.PointsOfInterest, ours.PointsOfInterest, theirs.PointsOfInterest)
+
+	return merged, conflicts
+}
+
+func aspectField(aspect string) string {
+	switch aspect {
+	case changeMoved:
+		return "position"
+	case changeRestyled:
+		return "style"
+	}
+	return "details"
+}
+
+// merge3 picks the value that changed from base, failing when ours and theirs both changed it differently.
+// The values are pointers and so is the result.
+func merge3(base, ours, theirs interface{}) (interface{}, bool) {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(base, theirs):
+		return ours, true
+	case reflect.DeepEqual(base, ours):
+		return theirs, true
+	}
+	return ours, false
+}
+
+func optional(st spyglassStyle, ok bool) *spyglassStyle {
+	if !ok {
+		return nil
+	}
+	return &st
+}
+
+// mergeConnections keeps ours, adding what theirs added and dropping what theirs removed
+func mergeConnections(base, ours, theirs []spyglassConnection) []spyglassConnection {
+	inBase, inTheirs := connectionSet(base), connectionSet(theirs)
+
+	var merged []spyglassConnection
+	seen := make(map[spyglassConnection]bool)
+	for _, c := range ours {
+		k := connectionKey(c)
+		if (inBase[k] && !inTheirs[k]) || seen[k] {
+			continue
+		}
+		seen[k] = true
+		merged = append(merged, c)
+	}
+	for _, c := range theirs {
+		k := connectionKey(c)
+		if inBase[k] || seen[k] {
+			continue
+		}
+		seen[k] = true
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// mergeAnnotations keeps ours, adding what theirs added and dropping what theirs removed
+func mergeAnnotations(base, ours, theirs []spyglassAnnotation) []spyglassAnnotation {
+	inBase, inTheirs := annotationSet(base), annotationSet(theirs)
+
+	var merged []spyglassAnnotation
+	seen := make(map[spyglassAnnotation]bool)
+	for _, a := range ours {
+		if (inBase[a] && !inTheirs[a]) || seen[a] {
+			continue
+		}
+		seen[a] = true
+		merged = append(merged, a)
+	}
+	for _, a := range theirs {
+		if inBase[a] || seen[a] {
+			continue
+		}
+		seen[a] = true
+		merged = append(merged, a)
+	}
+	return merged
+}
+
+// mergePOIs keeps ours, adding what theirs added and dropping what theirs removed
+func mergePOIs(base, ours, theirs []spyglassPOI) []spyglassPOI {
+	inBase, inTheirs := poiSet(base), poiSet(theirs)
+
+	var merged []spyglassPOI
+	seen := make(map[spyglassPOI]bool)
+	for _, p := range ours {
+		if (inBase[p] && !inTheirs[p]) || seen[p] {
+			continue
+		}
+		seen[p] = true
+		merged = append(merged, p)
+	}
+	for _, p := range theirs {
+		if inBase[p] || seen[p] {
+			continue
+		}
+		seen[p] = true
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+// connectionKey treats a connection and its reverse as the same
+func connectionKey(c spyglassConnection) spyglassConnection {
+	if c.From > c.To {
+		c.From, c.To = c.To, c.From
+	}
+	return c
+}
+
+func connectionSet(cs []spyglassConnection) map[spyglassConnection]bool {
+	set := make(map[spyglassConnection]bool, len(cs))
+	for _, c := range cs {
+		set[connectionKey(c)] = true
+	}
+	return set
+}
+
+func annotationSet(as []spyglassAnnotation) map[spyglassAnnotation]bool {
+	set := make(map[spyglassAnnotation]bool, len(as))
+	for _, a := range as {
+		set[a] = true
+	}
+	return set
+}
+
+func poiSet(ps []spyglassPOI) map[spyglassPOI]bool {
+	set := make(map[spyglassPOI]bool, len(ps))
+	for _, p := range ps {
+		set[p] = true
+	}
+	return set
+}
+
+// systemIDs returns the ids found in any of the given system maps, sorted
+func systemIDs(systems ...map[int32]spyglassSystem) []int32 {
+	set := make(map[int32]bool)
+	for _, sys := range systems {
+		for id := range sys {
+			set[id] = true
+		}
+	}
+	return sortedIDs(set)
+}
+
+func classNames(classes ...map[string]spyglassStyle) []string {
+	set := make(map[string]bool)
+	for _, cl := range classes {
+		for name := range cl {
+			set[name] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
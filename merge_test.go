@@ -0,0 +1,294 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mergeBase is the common ancestor of the merge cases, built fresh for every case
+func mergeBase() spyglassMap {
+	return spyglassMap{
+		Name:  "Test",
+		Width: 400,
+		Systems: map[int32]spyglassSystem{
+			1: {ID: 1, Name: "Alpha", X: 10, Y: 10},
+			2: {ID: 2, Name: "Bravo", X: 100, Y: 10},
+			3: {ID: 3, Name: "Charlie", X: 200, Y: 10},
+		},
+		Connections:      []spyglassConnection{{From: 1, To: 2}, {From: 2, To: 3}},
+		Annotations:      []spyglassAnnotation{{Text: "north", X: 5, Y: 5}},
+		PointsOfInterest: []spyglassPOI{{Kind: poiStaging, System: 1}},
+	}
+}
+
+func TestDiffMaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *spyglassMap)
+		lines  []string
+	}{
+		{
+			name:   "no change",
+			change: func(m *spyglassMap) {},
+		},
+		{
+			name:   "map field",
+			change: func(m *spyglassMap) { m.Width = 500 },
+			lines:  []string{"map width changed"},
+		},
+		{
+			name: "moved and restyled",
+			change: func(m *spyglassMap) {
+				s := m.Systems[2]
+				s.X, s.Y = 110, 20
+				s.Classes = []string{"hub"}
+				m.Systems[2] = s
+			},
+			lines: []string{"system 2 (Bravo) moved from 100,10 to 110,20 restyled"},
+		},
+		{
+			name: "added and removed systems",
+			change: func(m *spyglassMap) {
+				delete(m.Systems, 3)
+				m.Systems[4] = spyglassSystem{ID: 4, Name: "Delta", X: 300, Y: 10}
+			},
+			lines: []string{"system 3 (Charlie) removed", "system 4 (Delta) added at 300,10"},
+		},
+		{
+			name: "reversed connection is the same",
+			change: func(m *spyglassMap) {
+				m.Connections = []spyglassConnection{{From: 2, To: 1}, {From: 2, To: 3}}
+			},
+		},
+		{
+			name: "connections, annotations and points of interest",
+			change: func(m *spyglassMap) {
+				m.Connections = []spyglassConnection{{From: 1, To: 2}, {From: 1, To: 3}}
+				m.Annotations = nil
+				m.PointsOfInterest = append(m.PointsOfInterest, spyglassPOI{Kind: poiMarket, System: 2, Label: "hub"})
+			},
+			lines: []string{
+				"connection 2-3 removed",
+				"connection 1-3 added",
+				"annotation 'north' at 5,5 removed",
+				"point of interest market 'hub' on system 2 added",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := mergeBase()
+			tt.change(&b)
+			got := DiffMaps(mergeBase(), b).Lines()
+			if !reflect.DeepEqual(got, tt.lines) {
+				t.Errorf("diff = %q, want %q", got, tt.lines)
+			}
+		})
+	}
+}
+
+func TestMergeMaps(t *testing.T) {
+	tests := []struct {
+		name      string
+		ours      func(m *spyglassMap)
+		theirs    func(m *spyglassMap)
+		check     func(t *testing.T, m spyglassMap)
+		conflicts []mergeConflict
+	}{
+		{
+			name:   "changes to different systems",
+			ours:   func(m *spyglassMap) { m.Systems[1] = spyglassSystem{ID: 1, Name: "Alpha", X: 20, Y: 10} },
+			theirs: func(m *spyglassMap) { m.Systems[2] = spyglassSystem{ID: 2, Name: "Bravo", X: 100, Y: 50} },
+			check: func(t *testing.T, m spyglassMap) {
+				if m.Systems[1].X != 20 || m.Systems[2].Y != 50 {
+					t.Errorf("systems = %v, want both moves", m.Systems)
+				}
+			},
+		},
+		{
+			name: "move and restyle of the same system",
+			ours: func(m *spyglassMap) { m.Systems[1] = spyglassSystem{ID: 1, Name: "Alpha", X: 20, Y: 10} },
+			theirs: func(m *spyglassMap) {
+				m.Systems[1] = spyglassSystem{ID: 1, Name: "Alpha", X: 10, Y: 10, Classes: []string{"hub"}}
+			},
+			check: func(t *testing.T, m spyglassMap) {
+				s := m.Systems[1]
+				if s.X != 20 || !reflect.DeepEqual(s.Classes, []string{"hub"}) {
+					t.Errorf("system 1 = %+v, want moved and restyled", s)
+				}
+			},
+		},
+		{
+			name:   "conflicting moves keep ours",
+			ours:   func(m *spyglassMap) { m.Systems[1] = spyglassSystem{ID: 1, Name: "Alpha", X: 20, Y: 10} },
+			theirs: func(m *spyglassMap) { m.Systems[1] = spyglassSystem{ID: 1, Name: "Alpha", X: 30, Y: 10} },
+			check: func(t *testing.T, m spyglassMap) {
+				if m.Systems[1].X != 20 {
+					t.Errorf("system 1 x = %d, want ours", m.Systems[1].X)
+				}
+			},
+			conflicts: []mergeConflict{{System: 1, Field: "position"}},
+		},
+		{
+			name:   "removed on one side",
+			ours:   func(m *spyglassMap) {},
+			theirs: func(m *spyglassMap) { delete(m.Systems, 3) },
+			check: func(t *testing.T, m spyglassMap) {
+				if _, ok := m.Systems[3]; ok {
+					t.Error("system 3 is still on the map")
+				}
+			},
+		},
+		{
+			name:   "removed on one side and changed on the other",
+			ours:   func(m *spyglassMap) { m.Systems[3] = spyglassSystem{ID: 3, Name: "Charlie", X: 250, Y: 10} },
+			theirs: func(m *spyglassMap) { delete(m.Systems, 3) },
+			check: func(t *testing.T, m spyglassMap) {
+				if m.Systems[3].X != 250 {
+					t.Errorf("system 3 = %+v, want ours", m.Systems[3])
+				}
+			},
+			conflicts: []mergeConflict{{System: 3, Field: "removal"}},
+		},
+		{
+			name:   "map fields",
+			ours:   func(m *spyglassMap) { m.Name = "Ours" },
+			theirs: func(m *spyglassMap) { m.Width = 600; m.Name = "Theirs" },
+			check: func(t *testing.T, m spyglassMap) {
+				if m.Name != "Ours" || m.Width != 600 {
+					t.Errorf("name, width = %q, %d, want Ours, 600", m.Name, m.Width)
+				}
+			},
+			conflicts: []mergeConflict{{Field: "name"}},
+		},
+		{
+			name: "connections, annotations and points of interest",
+			ours: func(m *spyglassMap) {
+				m.Connections = append(m.Connections, spyglassConnection{From: 1, To: 3})
+				m.PointsOfInterest = append(m.PointsOfInterest, spyglassPOI{Kind: poiHome, System: 3})
+			},
+			theirs: func(m *spyglassMap) {
+				m.Connections = m.Connections[:1]
+				m.Annotations = nil
+				m.PointsOfInterest = []spyglassPOI{{Kind: poiMarket, System: 2}}
+			},
+			check: func(t *testing.T, m spyglassMap) {
+				conns := []spyglassConnection{{From: 1, To: 2}, {From: 1, To: 3}}
+				if !reflect.DeepEqual(m.Connections, conns) {
+					t.Errorf("connections = %v, want %v", m.Connections, conns)
+				}
+				if len(m.Annotations) != 0 {
+					t.Errorf("annotations = %v, want none", m.Annotations)
+				}
+				points := []spyglassPOI{{Kind: poiHome, System: 3}, {Kind: poiMarket, System: 2}}
+				if !reflect.DeepEqual(m.PointsOfInterest, points) {
+					t.Errorf("points of interest = %v, want %v", m.PointsOfInterest, points)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ours, theirs := mergeBase(), mergeBase()
+			tt.ours(&ours)
+			tt.theirs(&theirs)
+
+			merged, conflicts := MergeMaps(mergeBase(), ours, theirs)
+			tt.check(t, merged)
+			if !reflect.DeepEqual(conflicts, tt.conflicts) {
+				t.Errorf("conflicts = %v, want %v", conflicts, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestPatchMapFile(t *testing.T) {
+	const doc = `# kept
+name: Test
+systems:
+  1:
+    # alpha
+    name: Alpha
+    x: 10
+    y: 10
+  2:
+    name: Bravo
+    x: 100 # bravo
+    y: 10
+`
+	dir := t.TempDir()
+	p := filepath.Join(dir, "Test.yaml")
+	err := os.WriteFile(p, []byte(doc), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := readRawMap(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := MergeMaps(old, old, old)
+	s := m.Systems[2]
+	s.Y = 50
+	m.Systems[2] = s
+	m.Systems[3] = spyglassSystem{ID: 3, Name: "Charlie", X: 200, Y: 10}
+
+	err = patchMapFile(p, p, old, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	for _, want := range []string{"# kept\n", "# alpha\n", "x: 100 # bravo\n", "    y: 50\n", "  3:\n    id: 3\n    name: Charlie\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("patched file lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "id:") != 1 {
+		t.Errorf("ids were added to untouched systems:\n%s", out)
+	}
+
+	got, err := readRawMap(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Systems, m.Systems) {
+		t.Errorf("read back %v, want %v", got.Systems, m.Systems)
+	}
+}
+
+func TestCheckPlainMap(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		doc  string
+		ok   bool
+	}{
+		{"map.yaml", "name: Test\nsystems: {}\n", true},
+		{"overlay.yaml", "base: Test\nmove:\n  1: {x: 1, y: 1}\n", false},
+		{"list.json", "[1, 2]", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, tt.name)
+			err := os.WriteFile(p, []byte(tt.doc), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkPlainMap(p)
+			if (err == nil) != tt.ok {
+				t.Errorf("checkPlainMap = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
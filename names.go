@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveNames fills in the name of every system on mp from New Eden, so a rename by CCP never leaves a map out of date.
+// A system New Eden does not know keeps the name the map gave it, or its id when it has none.
+func (em *EveMapper) resolveNames(mp *spyglassMap) {
+	ids := make([]int32, 0, len(mp.Systems))
+	for id := range mp.Systems {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		s := mp.Systems[id]
+		sys, ok := em.Systems[id]
+		switch {
+		case ok:
+			s.Name = sys.Name
+		case s.Name == "":
+			s.Name = strconv.Itoa(int(id))
+			fallthrough
+		default:
+			mp.Conflicts = append(mp.Conflicts, fmt.Sprintf("system %d (%s) is not in New Eden", id, s.Name))
+		}
+		mp.Systems[id] = s
+	}
+}
+
+// NormalizeMapFile checks the system names stored in the map or overlay file at p against New Eden.
+// Stale names are corrected, or every resolvable name is dropped when strip is set, and ids that do not resolve are reported.
+// The file is only rewritten when write is set, keeping its format, key order and comments.
+func (em *EveMapper) NormalizeMapFile(p string, strip, write bool) ([]string, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := filepath.Ext(p)
+	doc, err := parseMapDocument(data, ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", p, err)
+	}
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("'%s' is not a map file", p)
+	}
+
+	var report []string
+	changed := false
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		// Overlays hold their new systems under add
+		if k := doc.Content[i].Value; k != "systems" && k != "add" {
+			continue
+		}
+		systems := resolveAlias(doc.Content[i+1])
+		if systems.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for j := 0; j+1 < len(systems.Content); j += 2 {
+			key, sys := systems.Content[j], resolveAlias(systems.Content[j+1])
+			id, err := strconv.Atoi(key.Value)
+			if err != nil || sys.Kind != yaml.MappingNode {
+				report = append(report, fmt.Sprintf("line %d: '%s' is not a system id", key.Line, key.Value))
+				continue
+			}
+
+			info, ok := em.Systems[int32(id)]
+			if !ok {
+				report = append(report, fmt.Sprintf("system %d does not resolve in New Eden", id))
+				continue
+			}
+
+			for k := 0; k+1 < len(sys.Content); k += 2 {
+				if sys.Content[k].Value != "name" {
+					continue
+				}
+				name := sys.Content[k+1]
+				if strip {
+					sys.Content = append(sys.Content[:k], sys.Content[k+2:]...)
+					report = append(report, fmt.Sprintf("system %d: dropped name '%s'", id, name.Value))
+					changed = true
+				} else if name.Value != info.Name {
+					report = append(report, fmt.Sprintf("system %d: renamed '%s' to '%s'", id, name.Value, info.Name))
+					name.Value = info.Name
+					changed = true
+				}
+				break
+			}
+		}
+	}
+
+	if changed && write {
+		out, err := writeMapDocument(doc, ext)
+		if err != nil {
+			return report, err
+		}
+		err = os.WriteFile(p, out, 0644)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// mapFiles returns the paths of the map and overlay files with the given names, or all of them when there are none
+func mapFiles(names []string) ([]string, error) {
+	if len(names) == 0 {
+		var err error
+		names, err = listMaps()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	for _, name := range names {
+		found := false
+		for _, dir := range []string{mapsDir, overlaysDir} {
+			p, err := findMapFile(dir, name)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, p)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("map '%s': %w", name, fs.ErrNotExist)
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JumpDistances returns the number of gate jumps from the system from to every system within limit jumps of it,
+// a negative limit searches the whole of New Eden
+func (em *EveMapper) JumpDistances(from int32, limit int) map[int32]int {
+	dist := map[int32]int{from: 0}
+	if _, ok := em.Systems[from]; !ok {
+		return dist
+	}
+
+	queue := []int32{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if limit >= 0 && dist[cur] >= limit {
+			continue
+		}
+		for _, next := range em.neighbours(cur) {
+			if _, seen := dist[next]; seen {
+				continue
+			}
+			dist[next] = dist[cur] + 1
+			queue = append(queue, next)
+		}
+	}
+	return dist
+}
+
+// ShortestPath returns the systems on the shortest gate route from one system to another, both included.
+// It is empty when there is no route.
+func (em *EveMapper) ShortestPath(from, to int32) []int32 {
+	if _, ok := em.Systems[from]; !ok {
+		return nil
+	}
+	if from == to {
+		return []int32{from}
+	}
+
+	prev := map[int32]int32{from: from}
+	queue := []int32{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, next := range em.neighbours(cur) {
+			if _, seen := prev[next]; seen {
+				continue
+			}
+			prev[next] = cur
+			if next == to {
+				path := []int32{to}
+				for p := cur; p != from; p = prev[p] {
+					path = append(path, p)
+				}
+				path = append(path, from)
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// neighbours returns the systems one gate jump from id, sorted so that searches are deterministic
+func (em *EveMapper) neighbours(id int32) []int32 {
+	info := em.Systems[id]
+	out := make([]int32, 0, len(info.Stargates))
+	for _, gate := range info.Stargates {
+		out = append(out, gate.Destination.SystemID)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// FindSystem looks a system up by its id or its name, ignoring case
+func (em *EveMapper) FindSystem(ref string) (int32, error) {
+	ref = strings.TrimSpace(ref)
+	if id, err := strconv.Atoi(ref); err == nil {
+		if _, ok := em.Systems[int32(id)]; ok {
+			return int32(id), nil
+		}
+	}
+	for id, info := range em.Systems {
+		if strings.EqualFold(info.Name, ref) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("system '%s': %w", ref, fs.ErrNotExist)
+}
+
+// Route returns the shortest gate route through the waypoints in order, every system on it listed once
+func (em *EveMapper) Route(waypoints []int32) ([]int32, error) {
+	if len(waypoints) == 0 {
+		return nil, nil
+	}
+
+	route := []int32{waypoints[0]}
+	for i := 1; i < len(waypoints); i++ {
+		leg := em.ShortestPath(waypoints[i-1], waypoints[i])
+		if leg == nil {
+			return nil, fmt.Errorf("no gate route from %s to %s: %w", em.Systems[waypoints[i-1]].Name, em.Systems[waypoints[i]].Name, fs.ErrNotExist)
+		}
+		route = append(route, leg[1:]...)
+	}
+	return route, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+// testMapper returns a mapper over a small made up galaxy, so that tests do not depend on the New Eden data:
+//
+//	Alpha(1) - Bravo(2) - Charlie(3) - Delta(4) - Foxtrot(6) - Golf(7)
+//	    \                    /
+//	     ------ Echo(5) -----
+//
+// Hotel(8) has no gates at all.
+func testMapper() *EveMapper {
+	type sys struct {
+		id            int32
+		name          string
+		sec           float64
+		constellation int32
+		region        int32
+	}
+	systems := []sys{
+		{1, "Alpha", 0.9, 10, 100},
+		{2, "Bravo", 0.5, 10, 100},
+		{3, "Charlie", 0.1, 11, 100},
+		{4, "Delta", -0.7, 12, 200},
+		{5, "Echo", -0.3, 11, 100},
+		{6, "Foxtrot", 1.0, 12, 200},
+		{7, "Golf", 0.45, 13, 200},
+		{8, "Hotel", 0.0, 13, 200},
+	}
+	gates := [][2]int32{{1, 2}, {2, 3}, {1, 5}, {3, 4}, {4, 6}, {6, 7}, {5, 3}}
+
+	constellations := map[int32]string{10: "ConA", 11: "ConB", 12: "ConC", 13: "ConD"}
+	regions := map[int32]string{100: "RegOne", 200: "RegTwo"}
+
+	em := &EveMapper{Systems: make(map[int32]SystemInfo)}
+	for _, s := range systems {
+		em.Systems[s.id] = SystemInfo{
+			System: System{
+				SystemID:       s.id,
+				Name:           s.name,
+				SecurityStatus: s.sec,
+				Stargates:      make(map[int32]Stargate),
+			},
+			ConstellationID: s.constellation,
+			Constellation:   constellations[s.constellation],
+			RegionID:        s.region,
+			Region:          regions[s.region],
+		}
+	}
+	for i, g := range gates {
+		gate := int32(1000 + 2*i)
+		em.Systems[g[0]].Stargates[gate] = Stargate{StargateID: gate, Destination: StargateDestination{StargateID: gate + 1, SystemID: g[1]}}
+		em.Systems[g[1]].Stargates[gate+1] = Stargate{StargateID: gate + 1, Destination: StargateDestination{StargateID: gate, SystemID: g[0]}}
+	}
+	return em
+}
+
+func TestJumpDistances(t *testing.T) {
+	em := testMapper()
+	tests := []struct {
+		name  string
+		from  int32
+		limit int
+		want  map[int32]int
+	}{
+		{"whole galaxy", 1, -1, map[int32]int{1: 0, 2: 1, 5: 1, 3: 2, 4: 3, 6: 4, 7: 5}},
+		{"limited", 1, 2, map[int32]int{1: 0, 2: 1, 5: 1, 3: 2}},
+		{"no gates", 8, -1, map[int32]int{8: 0}},
+		{"unknown system", 99, -1, map[int32]int{99: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := em.JumpDistances(tt.from, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("JumpDistances(%d, %d) = %v, want %v", tt.from, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	em := testMapper()
+	tests := []struct {
+		name     string
+		from, to int32
+		want     []int32
+	}{
+		{"same system", 3, 3, []int32{3}},
+		{"neighbours", 1, 2, []int32{1, 2}},
+		{"across regions", 2, 7, []int32{2, 3, 4, 6, 7}},
+		// Both ways round are two jumps, the lower system id is taken first
+		{"tie", 1, 3, []int32{1, 2, 3}},
+		{"unreachable", 1, 8, nil},
+		{"unknown", 99, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := em.ShortestPath(tt.from, tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ShortestPath(%d, %d) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSystem(t *testing.T) {
+	em := testMapper()
+	tests := []struct {
+		ref  string
+		want int32
+		err  error
+	}{
+		{"4", 4, nil},
+		{"Delta", 4, nil},
+		{" golf ", 7, nil},
+		{"Zulu", 0, fs.ErrNotExist},
+		{"99", 0, fs.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := em.FindSystem(tt.ref)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("FindSystem(%q) = %d, %v, want %d, %v", tt.ref, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	em := testMapper()
+	tests := []struct {
+		name      string
+		waypoints []int32
+		want      []int32
+		err       error
+	}{
+		{"empty", nil, nil, nil},
+		{"single", []int32{4}, []int32{4}, nil},
+		{"through a waypoint", []int32{2, 5, 4}, []int32{2, 1, 5, 3, 4}, nil},
+		{"unreachable", []int32{1, 8}, nil, fs.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := em.Route(tt.waypoints)
+			if !reflect.DeepEqual(got, tt.want) || !errors.Is(err, tt.err) {
+				t.Errorf("Route(%v) = %v, %v, want %v, %v", tt.waypoints, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
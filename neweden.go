@@ -57,6 +57,15 @@ type (
 		Z float64 `json:"z"`
 	}
 
+	// SystemInfo is a system together with the constellation and region it belongs to
+	SystemInfo struct {
+		System
+		ConstellationID int32
+		Constellation   string
+		RegionID        int32
+		Region          string
+	}
+
 	SystemPlanet struct {
 		AsteroidBelts []int32 `json:"asteroid_belts,omitempty"`
 		Moons         []int32 `json:"moons,omitempty"`
@@ -89,4 +98,23 @@ func (ne NewEden) GetSystem(id int32) (System, error) {
 	}
 
 	return System{}, errors.New("system not found")
-}
\ No newline at end of file
+}
+
+// IndexSystems returns every system in New Eden by id, for lookups that would otherwise walk the whole galaxy
+func (ne NewEden) IndexSystems() map[int32]SystemInfo {
+	index := make(map[int32]SystemInfo)
+	for _, region := range ne {
+		for _, constellation := range region.Constellations {
+			for _, system := range constellation.Systems {
+				index[system.SystemID] = SystemInfo{
+					System:          system,
+					ConstellationID: constellation.ConstellationID,
+					Constellation:   constellation.Name,
+					RegionID:        region.RegionID,
+					Region:          region.Name,
+				}
+			}
+		}
+	}
+	return index
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/go-chi/chi"
+)
+
+const (
+	notesFile = "./notes.json"
+
+	// defaultNoteColor is used by themes that have no note colour
+	defaultNoteColor = "rgb(255,200,0)"
+)
+
+type (
+	// systemNote is a note left on a system by a scout, it shows on every map unless Map names a single one
+	systemNote struct {
+		ID       string    `json:"id"`
+		System   int32     `json:"system"`
+		Map      string    `json:"map,omitempty"`
+		Text     string    `json:"text"`
+		Author   string    `json:"author,omitempty"`
+		Created  time.Time `json:"created"`
+		Modified time.Time `json:"modified"`
+	}
+
+	// noteStore keeps the notes in memory and writes them to its file on every change
+	noteStore struct {
+		fileStore
+		mu    sync.RWMutex
+		notes []systemNote
+	}
+)
+
+// loadNotes reads the note store at p, a missing file is an empty store
+func loadNotes(p string) (*noteStore, error) {
+	ns := &noteStore{fileStore: fileStore{path: p}}
+	err := ns.load(&ns.notes)
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// save writes notes to disk and only then makes them the contents of the store, so a failed write changes
+// nothing. The caller must hold the write lock.
+func (ns *noteStore) save(notes []systemNote) error {
+	err := ns.fileStore.save(notes)
+	if err != nil {
+		return err
+	}
+	ns.notes = notes
+	return nil
+}
+
+// List returns the notes shown for system on the named map, a system or map of zero value matches all
+func (ns *noteStore) List(mapName string, system int32) []systemNote {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	var found []systemNote
+	for _, n := range ns.notes {
+		if system != 0 && n.System != system {
+			continue
+		}
+		if mapName != "" && n.Map != "" && n.Map != mapName {
+			continue
+		}
+		found = append(found, n)
+	}
+	return found
+}
+
+// BySystem returns the notes shown on the named map grouped by system, oldest first
+func (ns *noteStore) BySystem(mapName string) map[int32][]systemNote {
+	bySystem := make(map[int32][]systemNote)
+	for _, n := range ns.List(mapName, 0) {
+		bySystem[n.System] = append(bySystem[n.System], n)
+	}
+	for _, notes := range bySystem {
+		sort.Slice(notes, func(i, j int) bool { return notes[i].Created.Before(notes[j].Created) })
+	}
+	return bySystem
+}
+
+func (ns *noteStore) Add(n systemNote) (systemNote, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	now := time.Now().UTC()
+	n.ID = newRecordID(now)
+	n.Created = now
+	n.Modified = now
+
+	notes := append(append([]systemNote{}, ns.notes...), n)
+	return n, ns.save(notes)
+}
+
+// Edit replaces the text and author of a note, an empty author keeps the old one
+func (ns *noteStore) Edit(id, text, author string) (systemNote, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	for i, n := range ns.notes {
+		if n.ID != id {
+			continue
+		}
+		n.Text = text
+		if author != "" {
+			n.Author = author
+		}
+		n.Modified = time.Now().UTC()
+		notes := append([]systemNote{}, ns.notes...)
+		notes[i] = n
+		return n, ns.save(notes)
+	}
+	return systemNote{}, fmt.Errorf("note '%s': %w", id, fs.ErrNotExist)
+}
+
+func (ns *noteStore) Delete(id string) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	for i, n := range ns.notes {
+		if n.ID == id {
+			notes := append(append([]systemNote{}, ns.notes[:i]...), ns.notes[i+1:]...)
+			return ns.save(notes)
+		}
+	}
+	return fmt.Errorf("note '%s': %w", id, fs.ErrNotExist)
+}
+
+func (n systemNote) String() string {
+	out := n.Text
+	if n.Author != "" {
+		out = n.Author + ": " + out
+	}
+	return fmt.Sprintf("%s (%s)", out, n.Modified.Format("2006-01-02 15:04"))
+}
+
+// drawNoteIndicator marks a system that has notes with a dot in its top right corner, hovering it shows the notes
+func drawNoteIndicator(canvas *svg.SVG, s spyglassSystem, st spyglassStyle, notes []systemNote, p painter) {
+	if len(notes) == 0 {
+		return
+	}
+
+	lines := make([]string, 0, len(notes))
+	for _, n := range notes {
+		lines = append(lines, n.String())
+	}
+
+	canvas.Group(`class="notes"`)
+	canvas.Title(strings.Join(lines, "\n"))
+	canvas.Circle(int(s.X+st.Width)-3, int(s.Y)+3, 4, p.attrs("note-dot", p.theme.noteStyle())...)
+	canvas.Gend()
+}
+
+func (t mapTheme) noteStyle() string {
+	return fmt.Sprintf("fill:%s;stroke:%s;stroke-width:1px", t.noteColor(), t.Border)
+}
+
+func (t mapTheme) noteColor() string {
+	if t.Note != "" {
+		return t.Note
+	}
+	return defaultNoteColor
+}
+
+func (em *EveMapper) listNotes(w http.ResponseWriter, r *http.Request) {
+	system, ok := systemParam(w, r)
+	if !ok {
+		return
+	}
+
+	notes := em.Notes.List(r.URL.Query().Get("map"), system)
+	if notes == nil {
+		notes = []systemNote{}
+	}
+	writeJSON(w, 200, notes)
+}
+
+func (em *EveMapper) addNote(w http.ResponseWriter, r *http.Request) {
+	var n systemNote
+	err := json.NewDecoder(r.Body).Decode(&n)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if n.System == 0 || strings.TrimSpace(n.Text) == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("a note needs a system and a text"))
+		return
+	}
+	if _, ok := em.Systems[n.System]; !ok {
+		w.WriteHeader(400)
+		w.Write([]byte(fmt.Sprintf("system %d is not in New Eden", n.System)))
+		return
+	}
+
+	n, err = em.Notes.Add(n)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, 201, n)
+}
+
+func (em *EveMapper) editNote(w http.ResponseWriter, r *http.Request) {
+	var n systemNote
+	err := json.NewDecoder(r.Body).Decode(&n)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if strings.TrimSpace(n.Text) == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("a note needs a text"))
+		return
+	}
+
+	n, err = em.Notes.Edit(chi.URLParam(r, "note"), n.Text, n.Author)
+	if err != nil {
+		w.WriteHeader(storeErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, 200, n)
+}
+
+func (em *EveMapper) deleteNote(w http.ResponseWriter, r *http.Request) {
+	err := em.Notes.Delete(chi.URLParam(r, "note"))
+	if err != nil {
+		w.WriteHeader(storeErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	defaultPadding = 4
+
+	// maxOverlapRounds bounds the number of passes made over a map that cannot be untangled
+	maxOverlapRounds = 200
+)
+
+type (
+	// overlap is a system box colliding with another box, or with a connection between two other systems
+	overlap struct {
+		System int32
+		Other  int32
+		// Edge is set when the system collides with the connection between Edge[0] and Edge[1]
+		Edge [2]int32
+	}
+
+	// overlapReport lists the collisions found on a map, the moves that were made to resolve them and what was left
+	overlapReport struct {
+		Found     []overlap
+		Moves     map[int32][2]int32
+		Remaining []overlap
+	}
+
+	layoutBox struct {
+		id         int32
+		x, y, w, h float64
+	}
+)
+
+func (o overlap) String() string {
+	if o.Other != 0 {
+		return fmt.Sprintf("system %d overlaps system %d", o.System, o.Other)
+	}
+	return fmt.Sprintf("system %d lies on the connection %d-%d", o.System, o.Edge[0], o.Edge[1])
+}
+
+// mapEdges returns every connection drawn on mp once, gates from New Eden as well as custom connections
+func (em *EveMapper) mapEdges(mp spyglassMap) [][2]int32 {
+	var edges [][2]int32
+	for _, c := range em.MapConnections(mp) {
+		edges = append(edges, [2]int32{c.From, c.To})
+	}
+	return edges
+}
+
+// ResolveOverlaps moves the systems of mp apart until no box overlaps another box or a connection it is not part of,
+// keeping at least padding between them. Every move is as small as it can be and keeps systems on the same side of
+// each other, so the layout keeps its shape. The result only depends on the map, systems are always handled by id.
+func (em *EveMapper) ResolveOverlaps(mp *spyglassMap, padding int32) (overlapReport, error) {
+	report := overlapReport{Moves: make(map[int32][2]int32)}
+
+	ids := make([]int32, 0, len(mp.Systems))
+	for id := range mp.Systems {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	boxes := make(map[int32]*layoutBox, len(ids))
+	for _, id := range ids {
+		s := mp.Systems[id]
+		st, err := mp.SystemStyle(s)
+		if err != nil {
+			return report, err
+		}
+		boxes[id] = &layoutBox{id: id, x: float64(s.X), y: float64(s.Y), w: float64(st.Width), h: float64(st.Height)}
+	}
+
+	edges := em.mapEdges(*mp)
+	pad := float64(padding)
+
+	for round := 0; round < maxOverlapRounds; round++ {
+		found := findOverlaps(ids, boxes, edges, pad)
+		if round == 0 {
+			report.Found = found
+		}
+		if len(found) == 0 {
+			break
+		}
+
+		for _, o := range found {
+			b := boxes[o.System]
+			if o.Other != 0 {
+				separateBoxes(b, boxes[o.Other], pad)
+			} else {
+				clearEdge(b, boxes[o.Edge[0]], boxes[o.Edge[1]], pad)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		s := mp.Systems[id]
+		b := boxes[id]
+		x, y := int32(math.Round(b.x)), int32(math.Round(b.y))
+		if x != s.X || y != s.Y {
+			report.Moves[id] = [2]int32{x - s.X, y - s.Y}
+			s.X, s.Y = x, y
+			mp.Systems[id] = s
+		}
+		// Work on the rounded positions from here so the final check sees what will be saved
+		b.x, b.y = float64(x), float64(y)
+	}
+
+	report.Remaining = findOverlaps(ids, boxes, edges, pad)
+
+	return report, nil
+}
+
+func findOverlaps(ids []int32, boxes map[int32]*layoutBox, edges [][2]int32, pad float64) []overlap {
+	var found []overlap
+
+	for i, a := range ids {
+		for _, b := range ids[i+1:] {
+			if boxesOverlap(boxes[a], boxes[b], pad) {
+				found = append(found, overlap{System: a, Other: b})
+			}
+		}
+	}
+
+	for _, e := range edges {
+		x1, y1 := boxes[e[0]].center()
+		x2, y2 := boxes[e[1]].center()
+		for _, id := range ids {
+			if id == e[0] || id == e[1] {
+				continue
+			}
+			if segmentHitsBox(x1, y1, x2, y2, boxes[id], pad/2) {
+				found = append(found, overlap{System: id, Edge: e})
+			}
+		}
+	}
+
+	return found
+}
+
+func (b *layoutBox) center() (float64, float64) {
+	return b.x + b.w/2, b.y + b.h/2
+}
+
+func boxesOverlap(a, b *layoutBox, pad float64) bool {
+	return a.x < b.x+b.w+pad && b.x < a.x+a.w+pad && a.y < b.y+b.h+pad && b.y < a.y+a.h+pad
+}
+
+// separateBoxes pushes a and b apart along the axis where they overlap the least, each moving half the distance
+func separateBoxes(a, b *layoutBox, pad float64) {
+	ax, ay := a.center()
+	bx, by := b.center()
+
+	dx := (a.w+b.w)/2 + pad - math.Abs(ax-bx)
+	dy := (a.h+b.h)/2 + pad - math.Abs(ay-by)
+
+	if dx <= dy {
+		// Boxes on the same spot are split by id so the result does not depend on map order
+		dir := 1.0
+		if ax < bx || (ax == bx && a.id < b.id) {
+			dir = -1
+		}
+		a.x += dir * math.Ceil(dx/2)
+		b.x -= dir * math.Ceil(dx/2)
+		return
+	}
+
+	dir := 1.0
+	if ay < by || (ay == by && a.id < b.id) {
+		dir = -1
+	}
+	a.y += dir * math.Ceil(dy/2)
+	b.y -= dir * math.Ceil(dy/2)
+}
+
+// clearEdge moves box b off the line between the centers of from and to, towards the side its center is already on
+func clearEdge(b, from, to *layoutBox, pad float64) {
+	x1, y1 := from.center()
+	x2, y2 := to.center()
+	length := math.Hypot(x2-x1, y2-y1)
+	if length == 0 {
+		return
+	}
+	nx, ny := -(y2-y1)/length, (x2-x1)/length
+
+	cx, cy := b.center()
+	side := nx*(cx-x1) + ny*(cy-y1)
+	if side < 0 || (side == 0 && b.id%2 == 1) {
+		nx, ny = -nx, -ny
+	}
+
+	// The corner closest to the line decides how far the box has to move
+	nearest := math.Inf(1)
+	for _, c := range [][2]float64{{b.x, b.y}, {b.x + b.w, b.y}, {b.x, b.y + b.h}, {b.x + b.w, b.y + b.h}} {
+		d := nx*(c[0]-x1) + ny*(c[1]-y1)
+		if d < nearest {
+			nearest = d
+		}
+	}
+
+	shift := pad/2 - nearest
+	if shift <= 0 {
+		return
+	}
+	shift = math.Ceil(shift)
+	b.x += roundAway(nx * shift)
+	b.y += roundAway(ny * shift)
+}
+
+func roundAway(v float64) float64 {
+	if v < 0 {
+		return math.Floor(v)
+	}
+	return math.Ceil(v)
+}
+
+// segmentHitsBox reports whether the segment crosses the box grown by pad on every side
+func segmentHitsBox(x1, y1, x2, y2 float64, b *layoutBox, pad float64) bool {
+	minX, minY, maxX, maxY := b.x-pad, b.y-pad, b.x+b.w+pad, b.y+b.h+pad
+
+	// Liang-Barsky clipping
+	t0, t1 := 0.0, 1.0
+	dx, dy := x2-x1, y2-y1
+	for _, pq := range [][2]float64{{-dx, x1 - minX}, {dx, maxX - x1}, {-dy, y1 - minY}, {dy, maxY - y1}} {
+		p, q := pq[0], pq[1]
+		if p == 0 {
+			// Parallel to this side, a segment running along the edge only touches the box
+			if q <= 0 {
+				return false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return false
+			}
+			if t > t0 {
+				t0 = t
+			}
+		} else {
+			if t < t0 {
+				return false
+			}
+			if t < t1 {
+				t1 = t
+			}
+		}
+	}
+	return t0 < t1
+}
+
+// ResolveOverlapsFile resolves the overlaps of the map file at p and, when write is set, stores the new positions in it
+func (em *EveMapper) ResolveOverlapsFile(p string, padding int32, write bool) (overlapReport, error) {
+	m, err := em.readLayout(p)
+	if err != nil {
+		return overlapReport{}, err
+	}
+	err = checkPlainLayout(p, m)
+	if err != nil {
+		return overlapReport{}, err
+	}
+
+	report, err := em.ResolveOverlaps(&m, padding)
+	if err != nil || !write || len(report.Moves) == 0 {
+		return report, err
+	}
+
+	return report, writeLayout(p, m)
+}
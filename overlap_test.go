@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveOverlaps(t *testing.T) {
+	em := testMapper()
+	type pos [2]int32
+	tests := []struct {
+		name    string
+		systems map[int32]pos
+		found   []overlap
+		moves   map[int32][2]int32
+	}{
+		{
+			name:    "apart",
+			systems: map[int32]pos{1: {0, 0}, 8: {100, 0}},
+			moves:   map[int32][2]int32{},
+		},
+		{
+			name:    "overlapping boxes",
+			systems: map[int32]pos{1: {0, 0}, 8: {10, 5}},
+			found:   []overlap{{System: 1, Other: 8}},
+			moves:   map[int32][2]int32{1: {0, -11}, 8: {0, 11}},
+		},
+		{
+			// Boxes on the same spot are split by id
+			name:    "same spot",
+			systems: map[int32]pos{8: {0, 0}, 1: {0, 0}},
+			found:   []overlap{{System: 1, Other: 8}},
+			moves:   map[int32][2]int32{1: {0, -13}, 8: {0, 13}},
+		},
+		{
+			name:    "system on a gate",
+			systems: map[int32]pos{1: {0, 0}, 2: {200, 0}, 8: {100, 0}},
+			found:   []overlap{{System: 8, Edge: [2]int32{1, 2}}},
+			moves:   map[int32][2]int32{8: {0, 13}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every run must give the same result whatever order the systems come in
+			for run := 0; run < 5; run++ {
+				mp := spyglassMap{Systems: make(map[int32]spyglassSystem)}
+				for id, p := range tt.systems {
+					mp.Systems[id] = spyglassSystem{ID: id, X: p[0], Y: p[1]}
+				}
+
+				report, err := em.ResolveOverlaps(&mp, defaultPadding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(report.Found, tt.found) {
+					t.Fatalf("found %v, want %v", report.Found, tt.found)
+				}
+				if !reflect.DeepEqual(report.Moves, tt.moves) {
+					t.Fatalf("moves = %v, want %v", report.Moves, tt.moves)
+				}
+				if len(report.Remaining) > 0 {
+					t.Fatalf("overlaps remain: %v", report.Remaining)
+				}
+				for id, m := range tt.moves {
+					s := mp.Systems[id]
+					if want := (pos{tt.systems[id][0] + m[0], tt.systems[id][1] + m[1]}); (pos{s.X, s.Y}) != want {
+						t.Fatalf("system %d at %d,%d, want %v", id, s.X, s.Y, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSegmentHitsBox(t *testing.T) {
+	box := &layoutBox{x: 10, y: 10, w: 20, h: 10}
+	tests := []struct {
+		name           string
+		x1, y1, x2, y2 float64
+		pad            float64
+		hit            bool
+	}{
+		{"through", 0, 15, 40, 15, 0, true},
+		{"diagonal through", 0, 0, 40, 30, 0, true},
+		{"fully inside", 12, 12, 14, 14, 0, true},
+		{"starts inside", 15, 15, 50, 50, 0, true},
+		{"a point inside", 15, 15, 15, 15, 0, true},
+		{"above", 0, 5, 40, 5, 0, false},
+		{"above within padding", 0, 8, 40, 8, 3, true},
+		{"along the top edge", 0, 10, 40, 10, 0, false},
+		{"along the left edge", 10, 0, 10, 40, 0, false},
+		{"ends on the edge", 0, 15, 10, 15, 0, false},
+		{"starts on the edge going out", 30, 15, 40, 15, 0, false},
+		{"touches a corner", 0, 20, 20, 0, 0, false},
+		{"ends at a corner", 0, 0, 10, 10, 0, false},
+		{"ends before", 0, 15, 8, 15, 0, false},
+		{"diagonal past the corner", 0, 40, 40, 25, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := segmentHitsBox(tt.x1, tt.y1, tt.x2, tt.y2, box, tt.pad)
+			if got != tt.hit {
+				t.Errorf("segmentHitsBox = %v, want %v", got, tt.hit)
+			}
+		})
+	}
+}
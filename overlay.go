@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+)
+
+type (
+	// spyglassOverlay patches a base map with local changes so that they survive a regeneration of the dotlan maps.
+	// Overlays live in the overlays dir, one that shares its name with its base replaces that map.
+	spyglassOverlay struct {
+		Base        string `json:"base"`
+		Name        string `json:"name,omitempty"`
+		Author      string `json:"author,omitempty"`
+		Description string `json:"description,omitempty"`
+
+		Move        map[int32]spyglassPosition `json:"move,omitempty"`
+		Add         map[int32]spyglassSystem   `json:"add,omitempty"`
+		Remove      []int32                    `json:"remove,omitempty"`
+		Connections []spyglassConnection       `json:"connections,omitempty"`
+		Annotations []spyglassAnnotation       `json:"annotations,omitempty"`
+		Points      []spyglassPOI              `json:"poi,omitempty"`
+
+		Style   *spyglassStyle            `json:"style,omitempty"`
+		Classes map[string]spyglassStyle  `json:"classes,omitempty"`
+		Restyle map[int32]spyglassRestyle `json:"restyle,omitempty"`
+	}
+
+	// spyglassRestyle replaces the classes and style of a system on the base map
+	spyglassRestyle struct {
+		Classes []string       `json:"classes,omitempty"`
+		Style   *spyglassStyle `json:"style,omitempty"`
+	}
+
+	spyglassPosition struct {
+		X int32 `json:"x"`
+		Y int32 `json:"y"`
+	}
+)
+
+func readOverlayFile(name string) (spyglassOverlay, error) {
+	var ov spyglassOverlay
+	err := readMapData(overlaysDir, name, &ov)
+	if err != nil {
+		return ov, err
+	}
+	if ov.Base == "" {
+		return ov, fmt.Errorf("%w '%s': overlay has no base map", errMapDecode, name)
+	}
+	return ov, nil
+}
+
+// applyOverlay patches mp with the changes of ov. Changes that no longer fit the base map are skipped,
+// or applied anyway where that is harmless, and recorded in the Conflicts of the returned map.
+func applyOverlay(mp spyglassMap, ov spyglassOverlay) spyglassMap {
+	if ov.Name != "" {
+		mp.Name = ov.Name
+	}
+	if ov.Author != "" {
+		mp.Author = ov.Author
+	}
+	if ov.Description != "" {
+		mp.Description = ov.Description
+	}
+
+	for _, id := range ov.Remove {
+		if _, ok := mp.Systems[id]; !ok {
+			mp.Conflicts = append(mp.Conflicts, fmt.Sprintf("removed system %d is not on the base map", id))
+			continue
+		}
+		delete(mp.Systems, id)
+	}
+
+	for id, s := range ov.Add {
+		if _, ok := mp.Systems[id]; ok {
+			mp.Conflicts = append(mp.Conflicts, fmt.Sprintf("added system %d is already on the base map, replacing it", id))
+		}
+		s.ID = id
+		mp.Systems[id] = s
+	}
+
+	for id, pos := range ov.Move {
+		s, ok := mp.Systems[id]
+		if !ok {
+			mp.Conflicts = append(mp.Conflicts, fmt.Sprintf("moved system %d is not on the map", id))
+			continue
+		}
+		s.X = pos.X
+		s.Y = pos.Y
+		mp.Systems[id] = s
+	}
+
+	for _, c := range ov.Connections {
+		_, fok := mp.Systems[c.From]
+		_, tok := mp.Systems[c.To]
+		if !(fok && tok) {
+			mp.Conflicts = append(mp.Conflicts, fmt.Sprintf("connection %d-%d does not join two systems on the map", c.From, c.To))
+			continue
+		}
+		mp.Connections = append(mp.Connections, c)
+	}
+
+	mp.Annotations = append(mp.Annotations, ov.Annotations...)
+
+	for _, p := range ov.Points {
+		if _, ok := mp.Systems[p.System]; !ok {
+			mp.Conflicts = append(mp.Conflicts, fmt.Sprintf("point of interest %d is not on the map", p.System))
+		}
+		mp.PointsOfInterest = append(mp.PointsOfInterest, p)
+	}
+
+	if ov.Style != nil {
+		st := ov.Style
+		if mp.Style != nil {
+			merged := mp.Style.merge(*ov.Style)
+			st = &merged
+		}
+		mp.Style = st
+	}
+
+	for name, c := range ov.Classes {
+		if mp.Classes == nil {
+			mp.Classes = make(map[string]spyglassStyle)
+		}
+		mp.Classes[name] = c
+	}
+
+	for id, rs := range ov.Restyle {
+		s, ok := mp.Systems[id]
+		if !ok {
+			mp.Conflicts = append(mp.Conflicts, fmt.Sprintf("restyled system %d is not on the map", id))
+			continue
+		}
+		s.Classes = rs.Classes
+		s.Style = rs.Style
+		mp.Systems[id] = s
+	}
+
+	return mp
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// overlayBase is a small base map, built fresh for every case as overlays change the systems in place
+func overlayBase() spyglassMap {
+	return spyglassMap{
+		Name: "Base",
+		Systems: map[int32]spyglassSystem{
+			1: {ID: 1, Name: "Alpha", X: 10, Y: 10},
+			2: {ID: 2, Name: "Bravo", X: 100, Y: 10},
+		},
+		Connections: []spyglassConnection{{From: 1, To: 2}},
+	}
+}
+
+func TestApplyOverlay(t *testing.T) {
+	tests := []struct {
+		name      string
+		overlay   spyglassOverlay
+		systems   map[int32]spyglassSystem
+		conns     []spyglassConnection
+		conflicts int
+	}{
+		{
+			name:    "empty overlay keeps the base",
+			overlay: spyglassOverlay{Base: "Base"},
+			systems: overlayBase().Systems,
+			conns:   []spyglassConnection{{From: 1, To: 2}},
+		},
+		{
+			name:    "move",
+			overlay: spyglassOverlay{Base: "Base", Move: map[int32]spyglassPosition{2: {X: 120, Y: 40}}},
+			systems: map[int32]spyglassSystem{
+				1: {ID: 1, Name: "Alpha", X: 10, Y: 10},
+				2: {ID: 2, Name: "Bravo", X: 120, Y: 40},
+			},
+			conns: []spyglassConnection{{From: 1, To: 2}},
+		},
+		{
+			name: "add and connect",
+			overlay: spyglassOverlay{
+				Base:        "Base",
+				Add:         map[int32]spyglassSystem{3: {Name: "Charlie", X: 200, Y: 10}},
+				Connections: []spyglassConnection{{From: 2, To: 3}},
+			},
+			systems: map[int32]spyglassSystem{
+				1: {ID: 1, Name: "Alpha", X: 10, Y: 10},
+				2: {ID: 2, Name: "Bravo", X: 100, Y: 10},
+				3: {ID: 3, Name: "Charlie", X: 200, Y: 10},
+			},
+			conns: []spyglassConnection{{From: 1, To: 2}, {From: 2, To: 3}},
+		},
+		{
+			name:    "remove",
+			overlay: spyglassOverlay{Base: "Base", Remove: []int32{2}},
+			systems: map[int32]spyglassSystem{
+				1: {ID: 1, Name: "Alpha", X: 10, Y: 10},
+			},
+			conns: []spyglassConnection{{From: 1, To: 2}},
+		},
+		{
+			name: "changes to missing systems are conflicts",
+			overlay: spyglassOverlay{
+				Base:        "Base",
+				Remove:      []int32{7},
+				Move:        map[int32]spyglassPosition{8: {X: 1, Y: 1}},
+				Connections: []spyglassConnection{{From: 1, To: 9}},
+				Restyle:     map[int32]spyglassRestyle{9: {Classes: []string{"hub"}}},
+			},
+			systems:   overlayBase().Systems,
+			conns:     []spyglassConnection{{From: 1, To: 2}},
+			conflicts: 4,
+		},
+		{
+			name:      "adding a system already on the map replaces it",
+			overlay:   spyglassOverlay{Base: "Base", Add: map[int32]spyglassSystem{1: {Name: "Alpha", X: 50, Y: 50}}},
+			systems:   map[int32]spyglassSystem{1: {ID: 1, Name: "Alpha", X: 50, Y: 50}, 2: {ID: 2, Name: "Bravo", X: 100, Y: 10}},
+			conns:     []spyglassConnection{{From: 1, To: 2}},
+			conflicts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := applyOverlay(overlayBase(), tt.overlay)
+			if !reflect.DeepEqual(mp.Systems, tt.systems) {
+				t.Errorf("systems = %v, want %v", mp.Systems, tt.systems)
+			}
+			if !reflect.DeepEqual(mp.Connections, tt.conns) {
+				t.Errorf("connections = %v, want %v", mp.Connections, tt.conns)
+			}
+			if len(mp.Conflicts) != tt.conflicts {
+				t.Errorf("conflicts = %q, want %d", mp.Conflicts, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestApplyOverlayMetadataAndStyle(t *testing.T) {
+	base := overlayBase()
+	base.Style = &spyglassStyle{Fill: "rgb(255,255,255)", Stroke: "rgb(0,0,0)"}
+
+	mp := applyOverlay(base, spyglassOverlay{
+		Base:    "Base",
+		Name:    "Patched",
+		Style:   &spyglassStyle{Fill: "rgb(0,0,0)"},
+		Classes: map[string]spyglassStyle{"hub": {Fill: "rgb(255,0,0)"}},
+		Restyle: map[int32]spyglassRestyle{1: {Classes: []string{"hub"}}},
+	})
+
+	if mp.Name != "Patched" {
+		t.Errorf("name = %q, want Patched", mp.Name)
+	}
+	if mp.Style.Fill != "rgb(0,0,0)" || mp.Style.Stroke != "rgb(0,0,0)" {
+		t.Errorf("style = %+v, want the overlay fill over the base stroke", *mp.Style)
+	}
+	if _, ok := mp.Classes["hub"]; !ok {
+		t.Error("class hub was not added")
+	}
+	if got := mp.Systems[1].Classes; !reflect.DeepEqual(got, []string{"hub"}) {
+		t.Errorf("classes of system 1 = %v, want [hub]", got)
+	}
+}
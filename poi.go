@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/go-chi/chi"
+)
+
+const (
+	deploymentsFile = "./deployments.json"
+
+	poiStaging = "staging"
+	poiHome    = "home"
+	poiMarket  = "market"
+	poiFormup  = "formup"
+)
+
+// defaultPOIColor marks points of interest of a kind that has no colour of its own
+const defaultPOIColor = "rgb(128,0,128)"
+
+// poiColors are the default marker colours of the points of interest, used for what a theme leaves out
+var poiColors = map[string]string{
+	poiStaging: "rgb(220,60,20)",
+	poiHome:    "rgb(40,160,40)",
+	poiMarket:  "rgb(200,160,0)",
+	poiFormup:  "rgb(30,100,220)",
+}
+
+type (
+	// spyglassPOI marks a system of interest such as the staging system. With Ring set every system within
+	// that many jumps of it is outlined in the marker colour.
+	spyglassPOI struct {
+		Kind   string `json:"kind"`
+		System int32  `json:"system"`
+		Label  string `json:"label,omitempty"`
+		Color  string `json:"color,omitempty"`
+		Ring   int    `json:"ring,omitempty"`
+	}
+
+	// deployment is a set of points of interest kept outside the maps, for the maps it lists or all of them.
+	// Active deployments are shown unless a request picks one by name.
+	deployment struct {
+		Name   string        `json:"name"`
+		Active bool          `json:"active,omitempty"`
+		Maps   []string      `json:"maps,omitempty"`
+		Points []spyglassPOI `json:"points"`
+	}
+
+	// poiInfo is a point of interest as served over http, Jumps is set when a request asks for the distance from a system
+	poiInfo struct {
+		spyglassPOI
+		Name  string `json:"name"`
+		Jumps *int   `json:"jumps,omitempty"`
+	}
+)
+
+// readDeployments reads the deployments file, which is optional
+func readDeployments() ([]deployment, error) {
+	data, err := os.ReadFile(deploymentsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var deployments []deployment
+	err = json.Unmarshal(data, &deployments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode deployments: %w", err)
+	}
+	return deployments, nil
+}
+
+// PointsOfInterest returns the points of interest of mp together with those of the deployments that apply to it,
+// that is the named deployment or, when name is empty, every active one
+func (em *EveMapper) PointsOfInterest(mp spyglassMap, name string) ([]spyglassPOI, error) {
+	points := append([]spyglassPOI{}, mp.PointsOfInterest...)
+
+	deployments, err := readDeployments()
+	if err != nil {
+		return nil, err
+	}
+
+	found := name == ""
+	for _, d := range deployments {
+		if (name == "" && !d.Active) || (name != "" && d.Name != name) {
+			continue
+		}
+		found = true
+		if len(d.Maps) > 0 && !containsString(d.Maps, mp.ID) {
+			continue
+		}
+		points = append(points, d.Points...)
+	}
+	if !found {
+		return nil, fmt.Errorf("deployment '%s': %w", name, fs.ErrNotExist)
+	}
+
+	return points, nil
+}
+
+// poiColor is the colour of the marker and ring of p, its own colour or else the one of its kind
+func (t mapTheme) poiColor(p spyglassPOI) string {
+	if p.Color != "" {
+		return p.Color
+	}
+	if c, ok := t.POI[p.Kind]; ok && c != "" {
+		return c
+	}
+	if c, ok := poiColors[p.Kind]; ok {
+		return c
+	}
+	return defaultPOIColor
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// drawPOIRings outlines every system within the ring of a point of interest, drawn beneath the systems
+func (em *EveMapper) drawPOIRings(canvas *svg.SVG, mp spyglassMap, styles map[int32]spyglassStyle, points []spyglassPOI, pt painter) {
+	canvas.Gid("poi-rings")
+	for _, p := range points {
+		if p.Ring <= 0 {
+			continue
+		}
+		dist := em.JumpDistances(p.System, p.Ring)
+		for _, id := range systemIDs(mp.Systems) {
+			d, ok := dist[id]
+			if !ok {
+				continue
+			}
+			s, st := mp.Systems[id], styles[id]
+			// Closer systems get a stronger outline
+			opacity := 1 - 0.6*float64(d)/float64(p.Ring)
+			canvas.Roundrect(int(s.X)-3, int(s.Y)-3, int(st.Width)+6, int(st.Height)+6, systemRounded, systemRounded,
+				pt.attrs("poi-ring poi-ring--"+p.Kind, pt.theme.poiRingStyle(pt.theme.poiColor(p)),
+					ownColor("stroke", p), fmt.Sprintf("stroke-opacity:%.2f", opacity))...)
+		}
+	}
+	canvas.Gend()
+}
+
+// drawPOIMarkers puts the marker of each point of interest on the top left corner of its system
+func drawPOIMarkers(canvas *svg.SVG, mp spyglassMap, points []spyglassPOI, pt painter) {
+	canvas.Gid("poi")
+	offsets := make(map[int32]int)
+	for _, p := range points {
+		s, ok := mp.Systems[p.System]
+		if !ok {
+			continue
+		}
+
+		// Several markers on one system are lined up along its top edge
+		x := int(s.X) + 2 + offsets[p.System]*11
+		y := int(s.Y) - 5
+		offsets[p.System]++
+
+		label := p.Label
+		if label == "" {
+			label = p.Kind
+		}
+
+		canvas.Group(`class="poi poi-` + p.Kind + `"`)
+		canvas.Title(label)
+		drawPOIIcon(canvas, p.Kind, x, y, pt.attrs("poi-marker poi-marker--"+p.Kind, pt.theme.poiMarkerStyle(pt.theme.poiColor(p)), ownColor("fill", p)), pt)
+		canvas.Gend()
+	}
+	canvas.Gend()
+}
+
+// ownColor is the inline declaration of the colour set on p itself, which no stylesheet can know
+func ownColor(property string, p spyglassPOI) string {
+	if p.Color == "" {
+		return ""
+	}
+	return property + ":" + p.Color
+}
+
+func (t mapTheme) poiRingStyle(color string) string {
+	return fmt.Sprintf("fill:none;stroke:%s;stroke-width:2px;stroke-dasharray:4,2", color)
+}
+
+func (t mapTheme) poiMarkerStyle(color string) string {
+	return fmt.Sprintf("fill:%s;stroke:%s;stroke-width:0.5px", color, t.Border)
+}
+
+// poiKinds are the kinds of point of interest that have a colour in t, sorted
+func (t mapTheme) poiKinds() []string {
+	var kinds []string
+	for kind := range poiColors {
+		kinds = append(kinds, kind)
+	}
+	for kind := range t.POI {
+		if _, ok := poiColors[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// drawPOIIcon draws a 10 by 10 icon centred on x, y with the given attributes, its details in the border colour
+func drawPOIIcon(canvas *svg.SVG, kind string, x, y int, style []string, pt painter) {
+	switch kind {
+	case poiStaging:
+		// A five pointed star
+		var xs, ys []int
+		for i := 0; i < 10; i++ {
+			r := 5.0
+			if i%2 == 1 {
+				r = 2.2
+			}
+			a := math.Pi/2 + float64(i)*math.Pi/5
+			xs = append(xs, x+int(math.Round(r*math.Cos(a))))
+			ys = append(ys, y-int(math.Round(r*math.Sin(a))))
+		}
+		canvas.Polygon(xs, ys, style...)
+	case poiHome:
+		canvas.Polygon([]int{x - 5, x, x + 5, x + 4, x + 4, x - 4, x - 4}, []int{y, y - 5, y, y, y + 5, y + 5, y}, style...)
+	case poiMarket:
+		canvas.Circle(x, y, 5, style...)
+		canvas.Text(x, y+3, "$", pt.text("poi-symbol", pt.theme.Border, 8, "middle", "font-weight:bold")...)
+	case poiFormup:
+		canvas.Line(x-4, y-5, x-4, y+5, pt.attrs("poi-pole", "stroke:"+pt.theme.Border+";stroke-width:1px")...)
+		canvas.Polygon([]int{x - 4, x + 5, x - 4}, []int{y - 5, y - 2, y + 1}, style...)
+	default:
+		canvas.Rect(x-4, y-4, 8, 8, style...)
+	}
+}
+
+// viewPOI lists the points of interest of a map, with the jumps to each from the system given by the from parameter
+func (em *EveMapper) viewPOI(w http.ResponseWriter, r *http.Request) {
+	m, err := em.LoadMap(chi.URLParam(r, "map"))
+	if err != nil {
+		w.WriteHeader(mapErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	points, err := em.PointsOfInterest(m, r.URL.Query().Get("deployment"))
+	if err != nil {
+		w.WriteHeader(mapErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var dist map[int32]int
+	if from := r.URL.Query().Get("from"); from != "" {
+		id, err := strconv.Atoi(from)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		dist = em.JumpDistances(int32(id), -1)
+	}
+
+	out := make([]poiInfo, 0, len(points))
+	for _, p := range points {
+		info := poiInfo{spyglassPOI: p, Name: em.Systems[p.System].Name}
+		if d, ok := dist[p.System]; ok {
+			info.Jumps = &d
+		}
+		out = append(out, info)
+	}
+	writeJSON(w, 200, out)
+}
+
+func (em *EveMapper) viewDeployments(w http.ResponseWriter, r *http.Request) {
+	deployments, err := readDeployments()
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if deployments == nil {
+		deployments = []deployment{}
+	}
+	writeJSON(w, 200, deployments)
+}
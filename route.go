@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+)
+
+const (
+	// defaultRouteColor is used by themes that have no route colour
+	defaultRouteColor = "rgb(255,140,0)"
+	// routeNames is how many systems of an off map stretch are named in its summary
+	routeNames = 4
+)
+
+// routeWaypoints resolves the origin, waypoints and destination of the render options, in that order
+func (em *EveMapper) routeWaypoints(opts renderOptions) ([]int32, error) {
+	var refs []string
+	if opts.Origin != "" {
+		refs = append(refs, opts.Origin)
+	}
+	for _, w := range strings.Split(opts.Waypoints, ",") {
+		if strings.TrimSpace(w) != "" {
+			refs = append(refs, w)
+		}
+	}
+	if opts.Destination != "" {
+		refs = append(refs, opts.Destination)
+	}
+
+	ids := make([]int32, 0, len(refs))
+	for _, ref := range refs {
+		id, err := em.FindSystem(ref)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (t mapTheme) routeColor() string {
+	if t.Route != "" {
+		return t.Route
+	}
+	return defaultRouteColor
+}
+
+func (t mapTheme) routeLineStyle() string {
+	return fmt.Sprintf("fill:none;stroke:%s;stroke-width:5px;stroke-linecap:round;stroke-opacity:0.8", t.routeColor())
+}
+
+func (t mapTheme) routeHaloStyle() string {
+	return fmt.Sprintf("fill:%s;fill-opacity:0.6;stroke:none", t.routeColor())
+}
+
+func (t mapTheme) routeHopStyle() string {
+	return fmt.Sprintf("fill:%s;stroke:%s;stroke-width:0.5px", t.routeColor(), t.Border)
+}
+
+func (t mapTheme) routeExitStyle() string {
+	return fmt.Sprintf("stroke:%s;stroke-width:3px;stroke-dasharray:5,3", t.routeColor())
+}
+
+// drawRoute highlights the route beneath the systems: a halo around each system on it and thick lines along its jumps,
+// following the routed connections where the map has them
+func drawRoute(canvas *svg.SVG, mp spyglassMap, styles map[int32]spyglassStyle, routes map[[2]int32]edgeRoute, route []int32, p painter) {
+	canvas.Gid("route")
+	for i := 1; i < len(route); i++ {
+		a, aok := mp.Systems[route[i-1]]
+		b, bok := mp.Systems[route[i]]
+		if !aok || !bok {
+			continue
+		}
+
+		style := p.attrs("route-line", p.theme.routeLineStyle())
+		key := [2]int32{a.ID, b.ID}
+		if key[0] > key[1] {
+			key[0], key[1] = key[1], key[0]
+		}
+		if r, ok := routes[key]; ok && !r.straight() {
+			canvas.Path(r.path(), style...)
+			continue
+		}
+		x1, y1 := styles[a.ID].Center(a)
+		x2, y2 := styles[b.ID].Center(b)
+		canvas.Line(int(x1), int(y1), int(x2), int(y2), style...)
+	}
+
+	for _, id := range route {
+		s, ok := mp.Systems[id]
+		if !ok {
+			continue
+		}
+		st := styles[id]
+		canvas.Roundrect(int(s.X)-4, int(s.Y)-4, int(st.Width)+8, int(st.Height)+8, systemRounded, systemRounded,
+			p.attrs("route-halo", p.theme.routeHaloStyle())...)
+	}
+	canvas.Gend()
+}
+
+// drawRouteHops numbers the systems on the route and sums up the parts of the route that leave the map at the map edge
+func (em *EveMapper) drawRouteHops(canvas *svg.SVG, mp spyglassMap, styles map[int32]spyglassStyle, route []int32, p painter) {
+	canvas.Gid("route-hops")
+	for hop, id := range route {
+		s, ok := mp.Systems[id]
+		if !ok {
+			continue
+		}
+		st := styles[id]
+		x, y := int(s.X+st.Width), int(s.Y+st.Height)
+		canvas.Circle(x, y, 6, p.attrs("route-hop", p.theme.routeHopStyle())...)
+		canvas.Text(x, y+3, strconv.Itoa(hop), p.text("route-hop-number", p.theme.Border, 8, "middle", "font-weight:bold")...)
+	}
+
+	// Every stretch of the route off the map is written at the edge nearest to where it leaves, or enters when it starts off the map
+	for i := 0; i < len(route); {
+		if _, ok := mp.Systems[route[i]]; ok {
+			i++
+			continue
+		}
+		j := i
+		for j < len(route) {
+			if _, ok := mp.Systems[route[j]]; ok {
+				break
+			}
+			j++
+		}
+
+		var anchor int32
+		var summary string
+		names := em.routeNames(route[i:j])
+		switch {
+		case i > 0:
+			anchor = route[i-1]
+			summary = fmt.Sprintf("hops %d-%d: %d jumps off map via %s", i, j-1, j-i, names)
+		case j < len(route):
+			anchor = route[j]
+			summary = fmt.Sprintf("hops 0-%d: %d jumps off map from %s", j-1, j-i, names)
+		default:
+			// None of the route is on the map, so it is written next to the system closest to where it starts
+			summary = fmt.Sprintf("hops 0-%d: %d jumps off map, %s", j-1, j-i, names)
+			var ok bool
+			anchor, ok = em.nearestOnMap(mp, route[0])
+			if !ok {
+				canvas.Text(3, 10, summary, p.text("route-summary", p.theme.Text, 9, "start", "font-weight:bold")...)
+				break
+			}
+		}
+		if anchor != 0 {
+			em.drawRouteExit(canvas, mp, styles[anchor], mp.Systems[anchor], summary, p)
+		}
+		i = j
+	}
+	canvas.Gend()
+}
+
+// nearestOnMap returns the system on mp that is the fewest jumps from id, the lowest id of those equally far.
+// It fails when no system on the map can be reached.
+func (em *EveMapper) nearestOnMap(mp spyglassMap, id int32) (int32, bool) {
+	dist := em.JumpDistances(id, -1)
+
+	nearest, best := int32(0), -1
+	for _, sid := range systemIDs(mp.Systems) {
+		d, ok := dist[sid]
+		if ok && (best < 0 || d < best) {
+			nearest, best = sid, d
+		}
+	}
+	return nearest, best >= 0
+}
+
+// routeNames lists the first systems of an off map stretch, and the last one when there are too many to name
+func (em *EveMapper) routeNames(ids []int32) string {
+	var names []string
+	for i, id := range ids {
+		if i == routeNames-1 && len(ids) > routeNames {
+			names = append(names, "…", em.Systems[ids[len(ids)-1]].Name)
+			break
+		}
+		names = append(names, em.Systems[id].Name)
+	}
+	return strings.Join(names, " → ")
+}
+
+// drawRouteExit draws a dashed line from a system to the nearest edge of the map with the summary written along that edge
+func (em *EveMapper) drawRouteExit(canvas *svg.SVG, mp spyglassMap, st spyglassStyle, s spyglassSystem, summary string, p painter) {
+	cx, cy := st.Center(s)
+	x, y := float64(cx), float64(cy)
+	w, h := float64(mp.Width), float64(mp.Height)
+
+	// Distance to the left, right, top and bottom edges
+	dists := []float64{x, w - x, y, h - y}
+	edge := 0
+	for i, d := range dists {
+		if d < dists[edge] {
+			edge = i
+		}
+	}
+
+	ex, ey := x, y
+	anchor := "middle"
+	tx, ty := x, y
+	switch edge {
+	case 0:
+		ex, tx, ty, anchor = 0, 3, y-4, "start"
+	case 1:
+		ex, tx, ty, anchor = w, w-3, y-4, "end"
+	case 2:
+		ey, ty = 0, 10
+	case 3:
+		ey, ty = h, h-4
+	}
+	// Keep text along the top and bottom inside the map
+	if edge >= 2 {
+		switch {
+		case tx < w/4:
+			tx, anchor = math.Max(tx-float64(st.Width)/2, 3), "start"
+		case tx > 3*w/4:
+			tx, anchor = math.Min(tx+float64(st.Width)/2, w-3), "end"
+		}
+	}
+
+	canvas.Line(int(cx), int(cy), int(ex), int(ey), p.attrs("route-exit", p.theme.routeExitStyle())...)
+	canvas.Text(int(tx), int(ty), summary, p.text("route-summary", p.theme.Text, 9, anchor, "font-weight:bold")...)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// Ways of drawing the connections, straight lines are drawn whatever is in the way
+	edgesStraight   = "straight"
+	edgesCurved     = "curved"
+	edgesOrthogonal = "orthogonal"
+
+	// routePadding is the clearance kept around system boxes, routeStep how far each detour reaches out further
+	// and routeTries the number of detours tried on each side before settling for the one crossing the fewest boxes
+	routePadding = 3.0
+	routeStep    = 12.0
+	routeTries   = 8
+
+	// Edges whose middles lie within bundleDistance of each other and whose directions differ by less than
+	// bundleAngle radians are drawn as a bundle
+	bundleDistance = 30.0
+	bundleAngle    = 0.26
+
+	// curveSamples is the number of straight pieces a curve is checked as
+	curveSamples = 16
+)
+
+type (
+	point [2]float64
+
+	// edgeRoute is the path of a connection, a quadratic curve through its control point or a polyline
+	edgeRoute struct {
+		points []point
+		curve  bool
+	}
+
+	// router finds paths between the centres of system boxes that keep clear of the other boxes
+	router struct {
+		boxes []*layoutBox
+	}
+)
+
+func newRouter(mp spyglassMap, styles map[int32]spyglassStyle) *router {
+	rt := &router{}
+	for _, id := range systemIDs(mp.Systems) {
+		s, st := mp.Systems[id], styles[id]
+		rt.boxes = append(rt.boxes, &layoutBox{id: id, x: float64(s.X), y: float64(s.Y), w: float64(st.Width), h: float64(st.Height)})
+	}
+	return rt
+}
+
+// RouteConnections works out the path of every connection for the given mode. Each connection is tried as a straight line
+// first and only bent around the boxes in its way when needed. Routes only depend on the map so that it always renders the same.
+func RouteConnections(mode string, mp spyglassMap, styles map[int32]spyglassStyle, conns []mapConnection) map[[2]int32]edgeRoute {
+	rt := newRouter(mp, styles)
+
+	ends := make([][2]point, len(conns))
+	for i, c := range conns {
+		src, dst := mp.Systems[c.From], mp.Systems[c.To]
+		x1, y1 := styles[c.From].Center(src)
+		x2, y2 := styles[c.To].Center(dst)
+		ends[i] = [2]point{{float64(x1), float64(y1)}, {float64(x2), float64(y2)}}
+	}
+
+	bundles := bundleEdges(ends)
+
+	routes := make(map[[2]int32]edgeRoute, len(conns))
+	for i, c := range conns {
+		a, b := ends[i][0], ends[i][1]
+		var shared *point
+		if mid, ok := bundles[i]; ok {
+			shared = &mid
+		}
+		switch mode {
+		case edgesCurved:
+			routes[[2]int32{c.From, c.To}] = rt.routeCurved(a, b, c, shared)
+		case edgesOrthogonal:
+			routes[[2]int32{c.From, c.To}] = rt.routeOrthogonal(a, b, c, shared)
+		default:
+			routes[[2]int32{c.From, c.To}] = edgeRoute{points: []point{a, b}}
+		}
+	}
+	return routes
+}
+
+// bundleEdges groups edges that run side by side and returns the shared middle point of each edge in a bundle
+func bundleEdges(ends [][2]point) map[int]point {
+	group := make([]int, len(ends))
+	for i := range group {
+		group[i] = -1
+	}
+
+	mids := make([]point, len(ends))
+	angles := make([]float64, len(ends))
+	for i, e := range ends {
+		mids[i] = point{(e[0][0] + e[1][0]) / 2, (e[0][1] + e[1][1]) / 2}
+		// Direction does not matter, only the line the edge lies on
+		angles[i] = math.Mod(math.Atan2(e[1][1]-e[0][1], e[1][0]-e[0][0])+math.Pi, math.Pi)
+	}
+
+	// Greedy grouping in connection order keeps the bundles the same on every render
+	for i := range ends {
+		if group[i] >= 0 {
+			continue
+		}
+		group[i] = i
+		for j := i + 1; j < len(ends); j++ {
+			if group[j] >= 0 {
+				continue
+			}
+			da := math.Abs(angles[i] - angles[j])
+			da = math.Min(da, math.Pi-da)
+			if da < bundleAngle && math.Hypot(mids[i][0]-mids[j][0], mids[i][1]-mids[j][1]) < bundleDistance {
+				group[j] = i
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for i, g := range group {
+		members[g] = append(members[g], i)
+	}
+
+	shared := make(map[int]point)
+	for _, m := range members {
+		if len(m) < 2 {
+			continue
+		}
+		var sum point
+		for _, i := range m {
+			sum[0] += mids[i][0]
+			sum[1] += mids[i][1]
+		}
+		mid := point{sum[0] / float64(len(m)), sum[1] / float64(len(m))}
+		for _, i := range m {
+			shared[i] = mid
+		}
+	}
+	return shared
+}
+
+// hits counts the boxes other than those of the connection itself that the polyline crosses
+func (rt *router) hits(points []point, c mapConnection) int {
+	n := 0
+	for _, b := range rt.boxes {
+		if b.id == c.From || b.id == c.To {
+			continue
+		}
+		for i := 1; i < len(points); i++ {
+			if segmentHitsBox(points[i-1][0], points[i-1][1], points[i][0], points[i][1], b, routePadding) {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// best returns the first candidate that crosses no boxes, or else the one crossing the fewest
+func (rt *router) best(candidates []edgeRoute, c mapConnection) edgeRoute {
+	best, least := candidates[0], -1
+	for _, r := range candidates {
+		pts := r.points
+		if r.curve {
+			pts = sampleCurve(r.points[0], r.points[1], r.points[2])
+		}
+		n := rt.hits(pts, c)
+		if n == 0 {
+			return r
+		}
+		if least < 0 || n < least {
+			best, least = r, n
+		}
+	}
+	return best
+}
+
+// routeCurved bends a blocked edge into a quadratic curve, trying control points further and further to either side.
+// A blocked bundled edge is first tried through the middle it shares with its bundle.
+func (rt *router) routeCurved(a, b point, c mapConnection, shared *point) edgeRoute {
+	candidates := []edgeRoute{{points: []point{a, b}}}
+	if shared != nil {
+		// The control point lies twice as far out as the curve passes, so aim it to pull the curve through the shared middle
+		mid := point{(a[0] + b[0]) / 2, (a[1] + b[1]) / 2}
+		ctrl := point{2*shared[0] - mid[0], 2*shared[1] - mid[1]}
+		candidates = append(candidates, edgeRoute{points: []point{a, ctrl, b}, curve: true})
+	}
+
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return candidates[0]
+	}
+	nx, ny := -dy/length, dx/length
+	mid := point{(a[0] + b[0]) / 2, (a[1] + b[1]) / 2}
+	for k := 1; k <= routeTries; k++ {
+		for _, side := range []float64{1, -1} {
+			d := side * float64(k) * routeStep * 2
+			ctrl := point{mid[0] + nx*d, mid[1] + ny*d}
+			candidates = append(candidates, edgeRoute{points: []point{a, ctrl, b}, curve: true})
+		}
+	}
+	return rt.best(candidates, c)
+}
+
+// routeOrthogonal replaces a blocked edge by horizontal and vertical pieces, L shapes first and then
+// Z shapes with their middle piece moved further and further out. A bundled edge tries the channel of its bundle first.
+func (rt *router) routeOrthogonal(a, b point, c mapConnection, shared *point) edgeRoute {
+	candidates := []edgeRoute{{points: []point{a, b}}}
+	if shared != nil {
+		candidates = append(candidates,
+			edgeRoute{points: []point{a, {shared[0], a[1]}, {shared[0], b[1]}, b}},
+			edgeRoute{points: []point{a, {a[0], shared[1]}, {b[0], shared[1]}, b}},
+		)
+	}
+	candidates = append(candidates,
+		edgeRoute{points: []point{a, {b[0], a[1]}, b}},
+		edgeRoute{points: []point{a, {a[0], b[1]}, b}},
+	)
+
+	mx, my := (a[0]+b[0])/2, (a[1]+b[1])/2
+	for k := 1; k <= routeTries; k++ {
+		for _, side := range []float64{1, -1} {
+			d := side * float64(k) * routeStep
+			candidates = append(candidates,
+				edgeRoute{points: []point{a, {mx + d, a[1]}, {mx + d, b[1]}, b}},
+				edgeRoute{points: []point{a, {a[0], my + d}, {b[0], my + d}, b}},
+			)
+		}
+	}
+	return rt.best(candidates, c)
+}
+
+// sampleCurve returns points along the quadratic curve from a to b with control point ctrl
+func sampleCurve(a, ctrl, b point) []point {
+	pts := make([]point, 0, curveSamples+1)
+	for i := 0; i <= curveSamples; i++ {
+		t := float64(i) / curveSamples
+		u := 1 - t
+		pts = append(pts, point{
+			u*u*a[0] + 2*u*t*ctrl[0] + t*t*b[0],
+			u*u*a[1] + 2*u*t*ctrl[1] + t*t*b[1],
+		})
+	}
+	return pts
+}
+
+// straight reports whether the route is a single line
+func (r edgeRoute) straight() bool {
+	return !r.curve && len(r.points) == 2
+}
+
+// path returns the route as svg path data
+func (r edgeRoute) path() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "M%g,%g", math.Round(r.points[0][0]), math.Round(r.points[0][1]))
+	if r.curve {
+		fmt.Fprintf(&sb, " Q%g,%g %g,%g", math.Round(r.points[1][0]), math.Round(r.points[1][1]), math.Round(r.points[2][0]), math.Round(r.points[2][1]))
+		return sb.String()
+	}
+	for _, p := range r.points[1:] {
+		fmt.Fprintf(&sb, " L%g,%g", math.Round(p[0]), math.Round(p[1]))
+	}
+	return sb.String()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+)
+
+// routingMap lines three boxes up so that a connection from the first to the last runs through the middle one,
+// with a fourth box well below them
+func routingMap() (spyglassMap, map[int32]spyglassStyle) {
+	mp := spyglassMap{Systems: map[int32]spyglassSystem{
+		1: {ID: 1, X: 0, Y: 100},
+		2: {ID: 2, X: 100, Y: 100},
+		3: {ID: 3, X: 200, Y: 100},
+		4: {ID: 4, X: 100, Y: 300},
+	}}
+	styles := make(map[int32]spyglassStyle)
+	for id := range mp.Systems {
+		styles[id] = spyglassStyle{Width: 50, Height: 20}
+	}
+	return mp, styles
+}
+
+func TestRouteConnections(t *testing.T) {
+	mp, styles := routingMap()
+	blocked := mapConnection{From: 1, To: 3}
+	clear := mapConnection{From: 1, To: 2}
+	conns := []mapConnection{clear, blocked}
+
+	tests := []struct {
+		mode     string
+		straight bool
+		curve    bool
+	}{
+		{edgesStraight, true, false},
+		{"", true, false},
+		{edgesCurved, false, true},
+		{edgesOrthogonal, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			routes := RouteConnections(tt.mode, mp, styles, conns)
+			rt := newRouter(mp, styles)
+
+			if r := routes[[2]int32{clear.From, clear.To}]; !r.straight() {
+				t.Errorf("unblocked connection was bent: %v", r.points)
+			}
+
+			r := routes[[2]int32{blocked.From, blocked.To}]
+			if r.straight() != tt.straight || r.curve != tt.curve {
+				t.Fatalf("blocked connection = %+v, want straight %v, curve %v", r, tt.straight, tt.curve)
+			}
+			if tt.straight {
+				return
+			}
+
+			pts := r.points
+			if r.curve {
+				pts = sampleCurve(r.points[0], r.points[1], r.points[2])
+			} else {
+				for i := 1; i < len(pts); i++ {
+					if pts[i][0] != pts[i-1][0] && pts[i][1] != pts[i-1][1] {
+						t.Errorf("piece %v-%v is not horizontal or vertical", pts[i-1], pts[i])
+					}
+				}
+			}
+			if n := rt.hits(pts, blocked); n != 0 {
+				t.Errorf("route %v crosses %d boxes", r.points, n)
+			}
+		})
+	}
+}
+
+func TestRouteConnectionsBundledClear(t *testing.T) {
+	// Two parallel edges close enough to bundle, with nothing in their way
+	mp := spyglassMap{Systems: map[int32]spyglassSystem{
+		1: {ID: 1, X: 0, Y: 0},
+		2: {ID: 2, X: 200, Y: 0},
+		3: {ID: 3, X: 0, Y: 25},
+		4: {ID: 4, X: 200, Y: 25},
+	}}
+	styles := make(map[int32]spyglassStyle)
+	for id := range mp.Systems {
+		styles[id] = spyglassStyle{Width: 50, Height: 20}
+	}
+	conns := []mapConnection{{From: 1, To: 2}, {From: 3, To: 4}}
+
+	for _, mode := range []string{edgesCurved, edgesOrthogonal} {
+		t.Run(mode, func(t *testing.T) {
+			routes := RouteConnections(mode, mp, styles, conns)
+			for _, c := range conns {
+				if r := routes[[2]int32{c.From, c.To}]; !r.straight() {
+					t.Errorf("clear bundled connection %d-%d was bent: %v", c.From, c.To, r.points)
+				}
+			}
+		})
+	}
+}
+
+func TestBundleEdges(t *testing.T) {
+	ends := [][2]point{
+		{{0, 0}, {100, 0}},
+		{{0, 10}, {100, 12}},
+		{{0, 200}, {100, 200}},
+		{{50, -50}, {50, 50}},
+	}
+	shared := bundleEdges(ends)
+
+	if len(shared) != 2 {
+		t.Fatalf("bundled edges = %v, want the first two", shared)
+	}
+	if shared[0] != shared[1] {
+		t.Errorf("bundled edges do not share their middle: %v, %v", shared[0], shared[1])
+	}
+	if want := (point{50, 5.5}); shared[0] != want {
+		t.Errorf("shared middle = %v, want %v", shared[0], want)
+	}
+}
+
+func TestEdgeRoutePath(t *testing.T) {
+	tests := []struct {
+		name  string
+		route edgeRoute
+		path  string
+	}{
+		{"line", edgeRoute{points: []point{{0, 0}, {10.4, 20.6}}}, "M0,0 L10,21"},
+		{"polyline", edgeRoute{points: []point{{0, 0}, {10, 0}, {10, 10}}}, "M0,0 L10,0 L10,10"},
+		{"curve", edgeRoute{points: []point{{0, 0}, {5, 10}, {10, 0}}, curve: true}, "M0,0 Q5,10 10,0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.route.path(); got != tt.path {
+				t.Errorf("path = %q, want %q", got, tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	secHigh = "high"
+	secLow  = "low"
+	secNull = "null"
+
+	// Ways of colouring systems by their security status
+	secModeFill   = "fill"
+	secModeBorder = "border"
+)
+
+// securityPalette is the colour the game gives each security status, everything at or below 0.0 shares one red
+var securityPalette = map[string]string{
+	"1.0": "rgb(47,239,239)",
+	"0.9": "rgb(72,240,192)",
+	"0.8": "rgb(0,239,71)",
+	"0.7": "rgb(0,240,0)",
+	"0.6": "rgb(143,239,47)",
+	"0.5": "rgb(239,239,0)",
+	"0.4": "rgb(215,119,0)",
+	"0.3": "rgb(240,96,0)",
+	"0.2": "rgb(240,72,0)",
+	"0.1": "rgb(215,48,0)",
+	"0.0": "rgb(240,0,0)",
+}
+
+// displaySecurity rounds a security status the way the game shows it: to one decimal,
+// except that anything above 0.0 and below 0.05 still shows as 0.1
+func displaySecurity(sec float64) float64 {
+	if sec > 0 && sec < 0.05 {
+		return 0.1
+	}
+	// Adding zero turns a rounded -0.0 into 0.0
+	return math.Round(sec*10)/10 + 0
+}
+
+// securityBand is the band of a security status after the game's rounding
+func securityBand(sec float64) string {
+	switch d := displaySecurity(sec); {
+	case d >= 0.5:
+		return secHigh
+	case d > 0:
+		return secLow
+	}
+	return secNull
+}
+
+// formatSecurity is the security status as the game shows it
+func formatSecurity(sec float64) string {
+	return fmt.Sprintf("%.1f", displaySecurity(sec))
+}
+
+// securityColor returns the colour of a security status, from the theme when it has one for that value.
+// Null security values fall back to the colour of 0.0.
+func (t mapTheme) securityColor(sec float64) string {
+	key := formatSecurity(sec)
+	if c, ok := t.SecurityStatus[key]; ok {
+		return c
+	}
+	if c, ok := securityPalette[key]; ok {
+		return c
+	}
+	if c, ok := t.SecurityStatus["0.0"]; ok {
+		return c
+	}
+	return securityPalette["0.0"]
+}
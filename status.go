@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	statusFile = "./status.json"
+
+	statusClear   = "clear"
+	statusAlarm   = "alarm"
+	statusStale   = "stale"
+	statusUnknown = "unknown"
+
+	// staleAfter is how long a report is trusted before the system is shown as stale
+	staleAfter = 15 * time.Minute
+	// statusReload is how often the status file is checked for changes
+	statusReload = 5 * time.Second
+)
+
+type (
+	// SystemStatus is what is known about a system from intel
+	SystemStatus struct {
+		State      string    `json:"state"`
+		LastReport time.Time `json:"last_report"`
+		Text       string    `json:"text,omitempty"`
+	}
+
+	// StatusProvider tells the renderer the state of each system
+	StatusProvider interface {
+		Status(system int32) SystemStatus
+	}
+
+	// staticStatusProvider gives every system the same state, unknown by default
+	staticStatusProvider struct {
+		state SystemStatus
+	}
+
+	// fileStatusProvider reads the reports from a json file of system id to status,
+	// checking every statusReload whether it changed
+	fileStatusProvider struct {
+		mu       sync.RWMutex
+		path     string
+		modified time.Time
+		reports  map[int32]SystemStatus
+		lastErr  string
+	}
+
+	// memoryStatusProvider holds the reports pushed to it by the intel subsystem,
+	// systems without a report are passed on to its fallback
+	memoryStatusProvider struct {
+		mu       sync.RWMutex
+		reports  map[int32]SystemStatus
+		fallback StatusProvider
+	}
+)
+
+func (sp staticStatusProvider) Status(system int32) SystemStatus {
+	if sp.state.State == "" {
+		return SystemStatus{State: statusUnknown}
+	}
+	return sp.state
+}
+
+// newFileStatusProvider reads the status file at p and keeps checking it for changes in the background
+func newFileStatusProvider(p string) *fileStatusProvider {
+	sp := &fileStatusProvider{path: p}
+	sp.check()
+	go func() {
+		for range time.Tick(statusReload) {
+			sp.check()
+		}
+	}()
+	return sp
+}
+
+func (sp *fileStatusProvider) Status(system int32) SystemStatus {
+	sp.mu.RLock()
+	st, ok := sp.reports[system]
+	sp.mu.RUnlock()
+
+	if !ok {
+		return SystemStatus{State: statusUnknown}
+	}
+	return aged(st, time.Now())
+}
+
+// check reloads the file and warns about a failure once, not every time it is checked.
+// The reports read last are kept until the file can be read again.
+func (sp *fileStatusProvider) check() {
+	err := sp.reload()
+
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if msg != sp.lastErr && err != nil {
+		log.Printf("WARN: failed to read status file '%s': %v", sp.path, err)
+	}
+	sp.lastErr = msg
+}
+
+// reload reads the file again if it changed since it was last read, a missing file has no reports
+func (sp *fileStatusProvider) reload() error {
+	info, err := os.Stat(sp.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		sp.mu.Lock()
+		sp.reports = nil
+		sp.modified = time.Time{}
+		sp.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sp.mu.RLock()
+	unchanged := sp.reports != nil && info.ModTime().Equal(sp.modified)
+	sp.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(sp.path)
+	if err != nil {
+		return err
+	}
+	reports := make(map[int32]SystemStatus)
+	err = json.Unmarshal(data, &reports)
+	if err != nil {
+		return err
+	}
+
+	sp.mu.Lock()
+	sp.reports = reports
+	sp.modified = info.ModTime()
+	sp.mu.Unlock()
+	return nil
+}
+
+func newMemoryStatusProvider(fallback StatusProvider) *memoryStatusProvider {
+	return &memoryStatusProvider{
+		reports:  make(map[int32]SystemStatus),
+		fallback: fallback,
+	}
+}
+
+func (sp *memoryStatusProvider) Status(system int32) SystemStatus {
+	sp.mu.RLock()
+	st, ok := sp.reports[system]
+	sp.mu.RUnlock()
+
+	if !ok {
+		return sp.fallback.Status(system)
+	}
+	return aged(st, time.Now())
+}
+
+// Report records the state of a system, a report without a time is taken to be made now
+func (sp *memoryStatusProvider) Report(system int32, st SystemStatus) SystemStatus {
+	if st.LastReport.IsZero() {
+		st.LastReport = time.Now().UTC()
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.reports[system] = st
+	return st
+}
+
+// Reports returns a copy of every report held
+func (sp *memoryStatusProvider) Reports() map[int32]SystemStatus {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+
+	out := make(map[int32]SystemStatus, len(sp.reports))
+	for id, st := range sp.reports {
+		out[id] = aged(st, time.Now())
+	}
+	return out
+}
+
+// aged turns a report that is older than staleAfter into a stale one
+func aged(st SystemStatus, now time.Time) SystemStatus {
+	if st.State == "" {
+		st.State = statusUnknown
+	}
+	if st.State != statusUnknown && !st.LastReport.IsZero() && now.Sub(st.LastReport) > staleAfter {
+		st.State = statusStale
+	}
+	return st
+}
+
+// label is the line shown under the name of a system, the text of the report or else how old it is
+func (st SystemStatus) label(now time.Time) string {
+	if st.Text != "" {
+		return st.Text
+	}
+	if st.LastReport.IsZero() {
+		if st.State == statusUnknown {
+			return ""
+		}
+		return st.State
+	}
+
+	age := now.Sub(st.LastReport)
+	switch {
+	case age < time.Minute:
+		return st.State + " now"
+	case age < time.Hour:
+		return fmt.Sprintf("%s %dm", st.State, int(age/time.Minute))
+	}
+	return fmt.Sprintf("%s %dh", st.State, int(age/time.Hour))
+}
+
+// defaultStatusProvider reads reports from the status file when there is one and otherwise knows nothing.
+// Reports pushed over http go on top of it.
+func defaultStatusProvider() *memoryStatusProvider {
+	var base StatusProvider = staticStatusProvider{}
+	if _, err := os.Stat(statusFile); err == nil {
+		base = newFileStatusProvider(statusFile)
+	}
+	return newMemoryStatusProvider(base)
+}
+
+func (em *EveMapper) listStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, em.Intel.Reports())
+}
+
+// reportStatus takes a report for one system from the intel subsystem
+func (em *EveMapper) reportStatus(w http.ResponseWriter, r *http.Request) {
+	var report struct {
+		System int32 `json:"system"`
+		SystemStatus
+	}
+	err := json.NewDecoder(r.Body).Decode(&report)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := em.Systems[report.System]; !ok {
+		w.WriteHeader(400)
+		w.Write([]byte(fmt.Sprintf("system %d is not in New Eden", report.System)))
+		return
+	}
+	switch report.State {
+	case statusClear, statusAlarm, statusUnknown:
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte(fmt.Sprintf("state must be '%s', '%s' or '%s'", statusClear, statusAlarm, statusUnknown)))
+		return
+	}
+
+	writeJSON(w, 200, em.Intel.Report(report.System, report.SystemStatus))
+}
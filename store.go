@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// fileStore is a list of records kept as a json file. Changes are written to a temporary file that replaces
+// the store once it is complete, so a failed write leaves the old contents intact.
+type fileStore struct {
+	path string
+}
+
+// load decodes the store into dest, a missing file leaves dest empty
+func (s fileStore) load(dest interface{}) error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, dest)
+	if err != nil {
+		return fmt.Errorf("failed to decode '%s': %w", s.path, err)
+	}
+	return nil
+}
+
+// save replaces the contents of the store with v
+func (s fileStore) save(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := writeTemp(s.path, data)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// writeTemp writes data to a new hidden file next to p, ready to be renamed into place, and returns its path
+func writeTemp(p string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// newRecordID returns an id for a new record, unique as long as records are not added within the same nanosecond
+func newRecordID(now time.Time) string {
+	return strconv.FormatInt(now.UnixNano(), 36)
+}
+
+// storeErrorStatus is the http status for an error changing a record, a record that does not exist is a 404
+func storeErrorStatus(err error) int {
+	if errors.Is(err, fs.ErrNotExist) {
+		return 404
+	}
+	return 500
+}
+
+// systemParam reads the optional system id query parameter, zero when it is left out
+func systemParam(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	sys := r.URL.Query().Get("system")
+	if sys == "" {
+		return 0, true
+	}
+
+	id, err := strconv.Atoi(sys)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return 0, false
+	}
+	return int32(id), true
+}
+
+// writeJSON sends v as the json body of a response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	err := enc.Encode(v)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxStubRings is how many steps away from its neighbour a stub may be placed before giving up
+const maxStubRings = 6
+
+type (
+	// stubReport lists the external systems added to a map, the neighbours there was no room for,
+	// and the external systems that no longer have a gate into the map
+	stubReport struct {
+		Added    []spyglassSystem
+		Unplaced []int32
+		Stale    []int32
+	}
+)
+
+// stubDirections are the eight directions a stub can be placed in from its neighbour
+var stubDirections = [][2]float64{
+	{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1},
+}
+
+// AddExternalStubs adds every gate neighbour of the systems on mp that is not on it yet as an external system,
+// placed next to its neighbour where it overlaps no other system or connection. External systems that have no gate
+// to a system of mp are reported as stale.
+func (em *EveMapper) AddExternalStubs(mp *spyglassMap, padding int32) (stubReport, error) {
+	var report stubReport
+
+	ids := make([]int32, 0, len(mp.Systems))
+	var cx, cy float64
+	var boxes []*layoutBox
+	for _, id := range systemIDs(mp.Systems) {
+		s := mp.Systems[id]
+		st, err := mp.SystemStyle(s)
+		if err != nil {
+			return report, err
+		}
+		b := &layoutBox{id: id, x: float64(s.X), y: float64(s.Y), w: float64(st.Width), h: float64(st.Height)}
+		boxes = append(boxes, b)
+		if !s.External {
+			ids = append(ids, id)
+			x, y := b.center()
+			cx += x
+			cy += y
+		}
+	}
+	if len(ids) > 0 {
+		cx /= float64(len(ids))
+		cy /= float64(len(ids))
+	}
+
+	boxByID := make(map[int32]*layoutBox, len(boxes))
+	for _, b := range boxes {
+		boxByID[b.id] = b
+	}
+	edges := em.mapEdges(*mp)
+
+	stubStyle, err := mp.SystemStyle(spyglassSystem{External: true})
+	if err != nil {
+		return report, err
+	}
+	pad := float64(padding)
+
+	for _, id := range ids {
+		info, ok := em.Systems[id]
+		if !ok {
+			continue
+		}
+
+		var neighbours []int32
+		for _, gate := range info.Stargates {
+			neighbours = append(neighbours, gate.Destination.SystemID)
+		}
+		sort.Slice(neighbours, func(i, j int) bool { return neighbours[i] < neighbours[j] })
+
+		for _, n := range neighbours {
+			if _, ok := mp.Systems[n]; ok {
+				continue
+			}
+			ninfo, ok := em.Systems[n]
+			if !ok {
+				continue
+			}
+
+			// The stub is drawn with a connection to every system it has a gate to, not only to the one it is placed by
+			var others []*layoutBox
+			for _, gate := range ninfo.Stargates {
+				if b, ok := boxByID[gate.Destination.SystemID]; ok && b.id != id {
+					others = append(others, b)
+				}
+			}
+			sort.Slice(others, func(i, j int) bool { return others[i].id < others[j].id })
+			links := append([]*layoutBox{boxByID[id]}, others...)
+
+			stub := &layoutBox{id: n, w: float64(stubStyle.Width), h: float64(stubStyle.Height)}
+			if !placeStub(stub, links, boxes, boxByID, edges, cx, cy, pad) {
+				report.Unplaced = append(report.Unplaced, n)
+				continue
+			}
+
+			s := spyglassSystem{
+				ID:       n,
+				Name:     ninfo.Name,
+				X:        int32(stub.x),
+				Y:        int32(stub.y),
+				External: true,
+			}
+			mp.Systems[n] = s
+			report.Added = append(report.Added, s)
+
+			boxes = append(boxes, stub)
+			boxByID[n] = stub
+			for _, l := range links {
+				edges = append(edges, [2]int32{l.id, n})
+			}
+		}
+	}
+
+	for _, id := range systemIDs(mp.Systems) {
+		s := mp.Systems[id]
+		if !s.External {
+			continue
+		}
+		info, ok := em.Systems[id]
+		stale := true
+		if ok {
+			for _, gate := range info.Stargates {
+				if d, ok := mp.Systems[gate.Destination.SystemID]; ok && !d.External {
+					stale = false
+					break
+				}
+			}
+		}
+		if stale {
+			report.Stale = append(report.Stale, id)
+		}
+	}
+
+	return report, nil
+}
+
+// placeStub looks for a free spot for stub around the first of the boxes it links to, trying the directions that point
+// away from the middle of the map first
+func placeStub(stub *layoutBox, links []*layoutBox, boxes []*layoutBox, boxByID map[int32]*layoutBox, edges [][2]int32, cx, cy, pad float64) bool {
+	from := links[0]
+	fx, fy := from.center()
+	ox, oy := fx-cx, fy-cy
+
+	dirs := make([]int, len(stubDirections))
+	for i := range dirs {
+		dirs[i] = i
+	}
+	score := func(i int) float64 {
+		d := stubDirections[i]
+		return -(d[0]*ox + d[1]*oy) / math.Hypot(d[0], d[1])
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return score(dirs[i]) < score(dirs[j]) })
+
+	stepX := (from.w+stub.w)/2 + pad*4
+	stepY := (from.h+stub.h)/2 + pad*4
+
+	for ring := 1; ring <= maxStubRings; ring++ {
+		for _, i := range dirs {
+			d := stubDirections[i]
+			x := fx + d[0]*stepX*float64(ring) - stub.w/2
+			y := fy + d[1]*stepY*float64(ring) - stub.h/2
+			if x < 0 || y < 0 {
+				continue
+			}
+			stub.x, stub.y = math.Round(x), math.Round(y)
+			if stubFits(stub, links, boxes, boxByID, edges, pad) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stubFits reports whether stub overlaps no box or connection, and none of its connections to links cross a box
+func stubFits(stub *layoutBox, links []*layoutBox, boxes []*layoutBox, boxByID map[int32]*layoutBox, edges [][2]int32, pad float64) bool {
+	sx, sy := stub.center()
+
+	for _, b := range boxes {
+		if boxesOverlap(stub, b, pad) {
+			return false
+		}
+		for _, l := range links {
+			lx, ly := l.center()
+			if b != l && segmentHitsBox(lx, ly, sx, sy, b, pad/2) {
+				return false
+			}
+		}
+	}
+
+	for _, e := range edges {
+		x1, y1 := boxByID[e[0]].center()
+		x2, y2 := boxByID[e[1]].center()
+		if segmentHitsBox(x1, y1, x2, y2, stub, pad/2) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AddExternalStubsFile adds external stubs to the map file at p and, when write is set, stores them in it.
+// With prune set stale external systems are removed as well.
+func (em *EveMapper) AddExternalStubsFile(p string, padding int32, prune, write bool) (stubReport, error) {
+	m, err := em.readLayout(p)
+	if err != nil {
+		return stubReport{}, err
+	}
+	err = checkPlainLayout(p, m)
+	if err != nil {
+		return stubReport{}, err
+	}
+
+	report, err := em.AddExternalStubs(&m, padding)
+	if err != nil || !write || (len(report.Added) == 0 && !(prune && len(report.Stale) > 0)) {
+		return report, err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return report, err
+	}
+	ext := filepath.Ext(p)
+	doc, err := parseMapDocument(data, ext)
+	if err != nil {
+		return report, err
+	}
+	systems := nodeValue(doc, "systems")
+	if systems == nil || systems.Kind != yaml.MappingNode {
+		return report, fmt.Errorf("'%s' has no systems", p)
+	}
+
+	if prune {
+		stale := make(map[string]bool, len(report.Stale))
+		for _, id := range report.Stale {
+			stale[strconv.Itoa(int(id))] = true
+		}
+		kept := systems.Content[:0]
+		for i := 0; i+1 < len(systems.Content); i += 2 {
+			if !stale[systems.Content[i].Value] {
+				kept = append(kept, systems.Content[i], systems.Content[i+1])
+			}
+		}
+		systems.Content = kept
+	}
+
+	for _, s := range report.Added {
+		node, err := systemNode(s)
+		if err != nil {
+			return report, err
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(int(s.ID))}
+		systems.Content = append(systems.Content, key, node)
+	}
+
+	out, err := writeMapDocument(doc, ext)
+	if err != nil {
+		return report, err
+	}
+	return report, os.WriteFile(p, out, 0644)
+}
+
+// systemNode converts s into a node that can be added to a map document
+func systemNode(s spyglassSystem) (*yaml.Node, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	n, err := parseMapDocument(data, ".json")
+	if err != nil {
+		return nil, err
+	}
+	blockStyle(n)
+	return n, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddExternalStubs(t *testing.T) {
+	em := testMapper()
+	tests := []struct {
+		name    string
+		systems map[int32]spyglassSystem
+		added   []int32
+		stale   []int32
+	}{
+		{
+			name: "neighbours of every system",
+			systems: map[int32]spyglassSystem{
+				1: {ID: 1, Name: "Alpha", X: 200, Y: 200},
+				2: {ID: 2, Name: "Bravo", X: 300, Y: 200},
+			},
+			added: []int32{5, 3},
+		},
+		{
+			name: "neighbours already on the map",
+			systems: map[int32]spyglassSystem{
+				1: {ID: 1, Name: "Alpha", X: 200, Y: 200},
+				2: {ID: 2, Name: "Bravo", X: 300, Y: 200},
+				3: {ID: 3, Name: "Charlie", X: 400, Y: 200, External: true},
+				5: {ID: 5, Name: "Echo", X: 200, Y: 300},
+			},
+			// Only systems of the map get stubs, not the external ones
+		},
+		{
+			name: "stale external systems",
+			systems: map[int32]spyglassSystem{
+				6: {ID: 6, Name: "Foxtrot", X: 200, Y: 200},
+				4: {ID: 4, Name: "Delta", X: 100, Y: 200, External: true},
+				// Golf leads to Foxtrot, Hotel has no gates and 99 is not in the galaxy
+				7:  {ID: 7, Name: "Golf", X: 300, Y: 200, External: true},
+				8:  {ID: 8, Name: "Hotel", X: 400, Y: 200, External: true},
+				99: {ID: 99, Name: "Nowhere", X: 500, Y: 200, External: true},
+			},
+			stale: []int32{8, 99},
+		},
+		{
+			name: "external systems only lead to each other",
+			systems: map[int32]spyglassSystem{
+				1: {ID: 1, Name: "Alpha", X: 200, Y: 200, External: true},
+				2: {ID: 2, Name: "Bravo", X: 300, Y: 200, External: true},
+			},
+			stale: []int32{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first map[int32]spyglassSystem
+			// The stubs must land on the same spots on every run
+			for run := 0; run < 3; run++ {
+				mp := spyglassMap{Systems: make(map[int32]spyglassSystem)}
+				for id, s := range tt.systems {
+					mp.Systems[id] = s
+				}
+
+				report, err := em.AddExternalStubs(&mp, defaultPadding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var added []int32
+				for _, s := range report.Added {
+					added = append(added, s.ID)
+					if !s.External || s.Name != em.Systems[s.ID].Name || !reflect.DeepEqual(mp.Systems[s.ID], s) {
+						t.Errorf("stub %+v is not an external system on the map", s)
+					}
+				}
+				if !reflect.DeepEqual(added, tt.added) {
+					t.Fatalf("added %v, want %v", added, tt.added)
+				}
+				if !reflect.DeepEqual(report.Stale, tt.stale) {
+					t.Errorf("stale %v, want %v", report.Stale, tt.stale)
+				}
+				if len(report.Unplaced) > 0 {
+					t.Errorf("unplaced %v", report.Unplaced)
+				}
+
+				if first == nil {
+					first = mp.Systems
+				} else if !reflect.DeepEqual(mp.Systems, first) {
+					t.Fatalf("run %d placed the stubs at %v, the first run at %v", run, mp.Systems, first)
+				}
+
+				placed := spyglassMap{Systems: make(map[int32]spyglassSystem)}
+				for id, s := range mp.Systems {
+					placed.Systems[id] = s
+				}
+				overlaps, err := em.ResolveOverlaps(&placed, defaultPadding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, o := range overlaps.Found {
+					for _, id := range added {
+						if o.System == id || o.Other == id || o.Edge[0] == id || o.Edge[1] == id {
+							t.Errorf("stub %d was placed on top of something: %s", id, o)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddExternalStubsFile(t *testing.T) {
+	const doc = `# Test map
+name: Test
+systems:
+  1:
+    name: Alpha # the hub
+    x: 200
+    y: 200
+  8:
+    name: Hotel
+    x: 400
+    y: 200
+    external: true
+`
+	tests := []struct {
+		name  string
+		prune bool
+		write bool
+		has   []string
+		lacks []string
+	}{
+		{"dry run", false, false, []string{"Hotel"}, []string{"Bravo", "Echo"}},
+		{"write", false, true, []string{"# Test map", "Alpha # the hub", "Hotel", "Bravo", "Echo"}, nil},
+		{"write and prune", true, true, []string{"# Test map", "Alpha # the hub", "Bravo", "Echo"}, []string{"Hotel"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "Test.yaml")
+			err := os.WriteFile(p, []byte(doc), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := testMapper().AddExternalStubsFile(p, defaultPadding, tt.prune, tt.write)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Added) != 2 || !reflect.DeepEqual(report.Stale, []int32{8}) {
+				t.Errorf("report = %+v, want two stubs added and Hotel stale", report)
+			}
+
+			data, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+			if !tt.write && out != doc {
+				t.Errorf("dry run changed the file:\n%s", out)
+			}
+			for _, s := range tt.has {
+				if !strings.Contains(out, s) {
+					t.Errorf("file lacks %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.lacks {
+				if strings.Contains(out, s) {
+					t.Errorf("file still has %q:\n%s", s, out)
+				}
+			}
+
+			m, err := readRawMap(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.write && !m.Systems[2].External {
+				t.Errorf("stub Bravo was not written as external: %+v", m.Systems[2])
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+
+	svg "github.com/ajstarks/svgo"
+)
+
+const (
+	systemWidth   = 50
+	systemHeight  = 22
+	systemRounded = 10
+
+	shapeRounded = "rounded"
+	shapeSquare  = "square"
+	shapeCircle  = "circle"
+	shapeHexagon = "hexagon"
+)
+
+type (
+	// spyglassStyle describes how a system is drawn, empty fields are inherited from the next style down:
+	// system style, then its classes in order, then the map style and finally defaultSystemStyle
+	spyglassStyle struct {
+		Fill        string  `json:"fill,omitempty"`
+		Stroke      string  `json:"stroke,omitempty"`
+		StrokeWidth float64 `json:"stroke_width,omitempty"`
+		Shape       string  `json:"shape,omitempty"`
+		Width       int32   `json:"width,omitempty"`
+		Height      int32   `json:"height,omitempty"`
+		FontSize    int32   `json:"font_size,omitempty"`
+		Label       string  `json:"label,omitempty"`
+	}
+)
+
+var defaultSystemStyle = spyglassStyle{
+	Fill:        "rgb(255,255,255)",
+	Stroke:      "rgb(0,0,0)",
+	StrokeWidth: 1,
+	Shape:       shapeRounded,
+	Width:       systemWidth,
+	Height:      systemHeight,
+	FontSize:    9,
+}
+
+// SystemStyle resolves the style that s is drawn with on mp
+func (mp spyglassMap) SystemStyle(s spyglassSystem) (spyglassStyle, error) {
+	st := defaultSystemStyle
+	if mp.Style != nil {
+		st = st.merge(*mp.Style)
+	}
+
+	for _, c := range s.Classes {
+		cs, ok := mp.Classes[c]
+		if !ok {
+			return st, fmt.Errorf("system %d (%s) uses unknown style class '%s'", s.ID, s.Name, c)
+		}
+		st = st.merge(cs)
+	}
+
+	if s.Style != nil {
+		st = st.merge(*s.Style)
+	}
+
+	switch st.Shape {
+	case shapeRounded, shapeSquare, shapeCircle, shapeHexagon:
+	default:
+		return st, fmt.Errorf("system %d (%s) has unknown shape '%s'", s.ID, s.Name, st.Shape)
+	}
+
+	return st, nil
+}
+
+// merge returns st with every field that is set in o replaced
+func (st spyglassStyle) merge(o spyglassStyle) spyglassStyle {
+	if o.Fill != "" {
+		st.Fill = o.Fill
+	}
+	if o.Stroke != "" {
+		st.Stroke = o.Stroke
+	}
+	if o.StrokeWidth != 0 {
+		st.StrokeWidth = o.StrokeWidth
+	}
+	if o.Shape != "" {
+		st.Shape = o.Shape
+	}
+	if o.Width != 0 {
+		st.Width = o.Width
+	}
+	if o.Height != 0 {
+		st.Height = o.Height
+	}
+	if o.FontSize != 0 {
+		st.FontSize = o.FontSize
+	}
+	if o.Label != "" {
+		st.Label = o.Label
+	}
+	return st
+}
+
+// Center returns the middle point of the box s is drawn in
+func (st spyglassStyle) Center(s spyglassSystem) (int32, int32) {
+	return s.X + st.Width/2, s.Y + st.Height/2
+}
+
+func drawSystemShape(canvas *svg.SVG, s spyglassSystem, st spyglassStyle, style ...string) {
+	x, y, w, h := int(s.X), int(s.Y), int(st.Width), int(st.Height)
+
+	switch st.Shape {
+	case shapeSquare:
+		canvas.Rect(x, y, w, h, style...)
+	case shapeCircle:
+		canvas.Ellipse(x+w/2, y+h/2, w/2, h/2, style...)
+	case shapeHexagon:
+		inset := h / 2
+		if inset > w/4 {
+			inset = w / 4
+		}
+		canvas.Polygon(
+			[]int{x + inset, x + w - inset, x + w, x + w - inset, x + inset, x},
+			[]int{y, y, y + h/2, y + h, y + h, y + h/2},
+			style...)
+	default:
+		// External systems keep the flattened corners they have always had
+		rnd := systemRounded
+		if s.External {
+			rnd = 0
+		}
+		canvas.Roundrect(x, y, w, h, systemRounded, rnd, style...)
+	}
+}
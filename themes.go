@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+)
+
+const (
+	themesDir    = "./themes"
+	defaultTheme = "light"
+
+	// jumpGate is the theme entry for lines that have no entry of their own
+	jumpGate     = "gate"
+	jumpBridge   = "bridge"
+	jumpWormhole = "wormhole"
+)
+
+type (
+	// mapTheme holds every colour, stroke and font of a rendered map that does not come from the map itself.
+	// Themes live in the themes dir in any map format, a theme file starts from the built in theme named by
+	// its base, or from light, and replaces what it sets.
+	mapTheme struct {
+		Name string `json:"name,omitempty"`
+		Base string `json:"base,omitempty"`
+
+		Background string `json:"background"`
+		Border     string `json:"border"`
+
+		// System is the style systems are drawn with unless the map styles them itself
+		System spyglassStyle `json:"system"`
+
+		Font      string `json:"font,omitempty"`
+		Text      string `json:"text"`
+		MutedText string `json:"muted_text"`
+
+		// Jumps styles the connections by type, Status the system fills by intel state,
+		// Security the shading of the security bands and SecurityStatus the colour of each rounded
+		// security status from 1.0 down to -1.0, the game's palette is used for values it leaves out
+		Jumps          map[string]themeLine `json:"jumps"`
+		Status         map[string]string    `json:"status"`
+		Security       map[string]string    `json:"security"`
+		SecurityStatus map[string]string    `json:"security_status,omitempty"`
+
+		Hull string `json:"hull"`
+		// Route is the colour of highlighted routes, a bright orange when left empty
+		Route string `json:"route,omitempty"`
+		// Focus is the gradient systems are shaded with by their jumps from a focus system, closest first
+		Focus []string `json:"focus,omitempty"`
+		// Heat is the gradient datasets are drawn with, from the lowest value to the highest
+		Heat []string `json:"heat,omitempty"`
+
+		// Note is the colour of the dot on systems with notes, a yellow when left empty
+		Note string `json:"note,omitempty"`
+		// Timers colours the countdown badges by how soon the timer comes out, from "later" over "day", "hours"
+		// and "hour" to "out", and their text by "text". Anything left out keeps the default colours.
+		Timers map[string]string `json:"timers,omitempty"`
+		// POI is the marker colour of each kind of point of interest, a colour set on the point itself wins
+		POI map[string]string `json:"poi,omitempty"`
+	}
+
+	themeLine struct {
+		Color string  `json:"color"`
+		Width float64 `json:"width,omitempty"`
+		Dash  string  `json:"dash,omitempty"`
+	}
+)
+
+// builtinThemes are the themes that exist without any theme files
+var builtinThemes = map[string]mapTheme{
+	"light": {
+		Background: "rgb(255,255,255)",
+		Border:     "rgb(0,0,0)",
+		System:     spyglassStyle{Fill: "rgb(255,255,255)", Stroke: "rgb(0,0,0)", StrokeWidth: 1},
+		Text:       "rgb(0,0,0)",
+		MutedText:  "rgb(100,100,140)",
+		Jumps: map[string]themeLine{
+			jumpGate:               {Color: "rgb(0,0,0)", Width: 1},
+			jumpBridge:             {Color: "rgb(0,160,0)", Width: 1, Dash: "6,3"},
+			jumpWormhole:           {Color: "rgb(140,0,200)", Width: 1, Dash: "2,3"},
+			jumpConstellation:      {Color: "rgb(0,0,0)", Width: 1},
+			jumpInterConstellation: {Color: "rgb(200,0,0)", Width: 1},
+			jumpInterRegion:        {Color: "rgb(140,0,160)", Width: 1.5},
+		},
+		Status: map[string]string{
+			statusClear: "rgb(200,255,200)",
+			statusAlarm: "rgb(255,64,64)",
+			statusStale: "rgb(255,200,120)",
+		},
+		Security: map[string]string{
+			secHigh: "rgb(80,160,255)",
+			secLow:  "rgb(255,170,0)",
+			secNull: "rgb(220,40,40)",
+		},
+		Hull: "rgb(120,120,160)",
+	},
+	"dark": {
+		Background: "rgb(24,26,30)",
+		Border:     "rgb(90,90,90)",
+		System:     spyglassStyle{Fill: "rgb(45,48,55)", Stroke: "rgb(160,160,160)", StrokeWidth: 1},
+		Text:       "rgb(225,225,225)",
+		MutedText:  "rgb(150,150,180)",
+		Jumps: map[string]themeLine{
+			jumpGate:               {Color: "rgb(130,130,130)", Width: 1},
+			jumpBridge:             {Color: "rgb(60,180,60)", Width: 1, Dash: "6,3"},
+			jumpWormhole:           {Color: "rgb(180,90,230)", Width: 1, Dash: "2,3"},
+			jumpConstellation:      {Color: "rgb(130,130,130)", Width: 1},
+			jumpInterConstellation: {Color: "rgb(200,80,80)", Width: 1},
+			jumpInterRegion:        {Color: "rgb(180,100,220)", Width: 1.5},
+		},
+		Status: map[string]string{
+			statusClear: "rgb(35,90,45)",
+			statusAlarm: "rgb(175,30,30)",
+			statusStale: "rgb(140,95,30)",
+		},
+		Security: map[string]string{
+			secHigh: "rgb(40,90,160)",
+			secLow:  "rgb(150,100,0)",
+			secNull: "rgb(140,30,30)",
+		},
+		Hull: "rgb(110,110,150)",
+	},
+	"dotlan-classic": {
+		Background: "rgb(255,255,255)",
+		Border:     "rgb(150,150,150)",
+		System:     spyglassStyle{Fill: "rgb(255,255,255)", Stroke: "rgb(0,0,0)", StrokeWidth: 1},
+		Font:       "Verdana,Arial,sans-serif",
+		Text:       "rgb(0,0,0)",
+		MutedText:  "rgb(80,80,80)",
+		Jumps: map[string]themeLine{
+			jumpGate:               {Color: "rgb(0,0,120)", Width: 1},
+			jumpBridge:             {Color: "rgb(0,150,0)", Width: 1, Dash: "4,2"},
+			jumpWormhole:           {Color: "rgb(150,0,150)", Width: 1, Dash: "2,2"},
+			jumpConstellation:      {Color: "rgb(0,0,120)", Width: 1},
+			jumpInterConstellation: {Color: "rgb(200,0,0)", Width: 1},
+			jumpInterRegion:        {Color: "rgb(150,0,150)", Width: 2},
+		},
+		Status: map[string]string{
+			statusClear: "rgb(220,255,220)",
+			statusAlarm: "rgb(255,80,80)",
+			statusStale: "rgb(255,220,150)",
+		},
+		Security: map[string]string{
+			secHigh: "rgb(100,170,255)",
+			secLow:  "rgb(255,180,60)",
+			secNull: "rgb(255,60,60)",
+		},
+		Hull: "rgb(170,170,170)",
+	},
+	"high-contrast": {
+		Background: "rgb(0,0,0)",
+		Border:     "rgb(255,255,255)",
+		System:     spyglassStyle{Fill: "rgb(0,0,0)", Stroke: "rgb(255,255,255)", StrokeWidth: 2},
+		Text:       "rgb(255,255,255)",
+		MutedText:  "rgb(255,255,0)",
+		Jumps: map[string]themeLine{
+			jumpGate:               {Color: "rgb(255,255,255)", Width: 2},
+			jumpBridge:             {Color: "rgb(0,255,0)", Width: 2, Dash: "6,3"},
+			jumpWormhole:           {Color: "rgb(255,0,255)", Width: 2, Dash: "2,3"},
+			jumpConstellation:      {Color: "rgb(255,255,255)", Width: 2},
+			jumpInterConstellation: {Color: "rgb(255,255,0)", Width: 2},
+			jumpInterRegion:        {Color: "rgb(0,255,255)", Width: 3},
+		},
+		Status: map[string]string{
+			statusClear: "rgb(0,100,0)",
+			statusAlarm: "rgb(200,0,0)",
+			statusStale: "rgb(0,0,170)",
+		},
+		Security: map[string]string{
+			secHigh: "rgb(0,120,255)",
+			secLow:  "rgb(255,200,0)",
+			secNull: "rgb(255,0,0)",
+		},
+		Hull: "rgb(255,255,255)",
+	},
+	// The colour blind safe themes keep to blue, orange and yellow, which stay apart without red-green vision
+	"deuteranopia": {
+		Background: "rgb(255,255,255)",
+		Border:     "rgb(0,0,0)",
+		System:     spyglassStyle{Fill: "rgb(255,255,255)", Stroke: "rgb(0,0,0)", StrokeWidth: 1},
+		Text:       "rgb(0,0,0)",
+		MutedText:  "rgb(0,90,140)",
+		Jumps: map[string]themeLine{
+			jumpGate:               {Color: "rgb(0,0,0)", Width: 1},
+			jumpBridge:             {Color: "rgb(0,114,178)", Width: 1, Dash: "6,3"},
+			jumpWormhole:           {Color: "rgb(204,121,167)", Width: 1, Dash: "2,3"},
+			jumpConstellation:      {Color: "rgb(0,0,0)", Width: 1},
+			jumpInterConstellation: {Color: "rgb(0,114,178)", Width: 1},
+			jumpInterRegion:        {Color: "rgb(213,94,0)", Width: 2},
+		},
+		Status: map[string]string{
+			statusClear: "rgb(170,215,245)",
+			statusAlarm: "rgb(230,159,0)",
+			statusStale: "rgb(240,228,66)",
+		},
+		Security: map[string]string{
+			secHigh: "rgb(0,114,178)",
+			secLow:  "rgb(240,228,66)",
+			secNull: "rgb(213,94,0)",
+		},
+		Hull: "rgb(100,100,100)",
+	},
+	"protanopia": {
+		Background: "rgb(255,255,255)",
+		Border:     "rgb(0,0,0)",
+		System:     spyglassStyle{Fill: "rgb(255,255,255)", Stroke: "rgb(0,0,0)", StrokeWidth: 1},
+		Text:       "rgb(0,0,0)",
+		MutedText:  "rgb(0,90,140)",
+		Jumps: map[string]themeLine{
+			jumpGate:               {Color: "rgb(0,0,0)", Width: 1},
+			jumpBridge:             {Color: "rgb(0,114,178)", Width: 1, Dash: "6,3"},
+			jumpWormhole:           {Color: "rgb(86,180,233)", Width: 1, Dash: "2,3"},
+			jumpConstellation:      {Color: "rgb(0,0,0)", Width: 1},
+			jumpInterConstellation: {Color: "rgb(0,114,178)", Width: 1},
+			jumpInterRegion:        {Color: "rgb(230,159,0)", Width: 2},
+		},
+		Status: map[string]string{
+			statusClear: "rgb(86,180,233)",
+			statusAlarm: "rgb(240,228,66)",
+			statusStale: "rgb(200,200,200)",
+		},
+		Security: map[string]string{
+			secHigh: "rgb(0,114,178)",
+			secLow:  "rgb(200,200,200)",
+			secNull: "rgb(240,228,66)",
+		},
+		Hull: "rgb(100,100,100)",
+	},
+}
+
+// LoadTheme returns the named theme, a theme file of that name takes precedence over the built in one
+func LoadTheme(name string) (mapTheme, error) {
+	if name == "" {
+		name = defaultTheme
+	}
+
+	var probe mapTheme
+	err := readMapData(themesDir, name, &probe)
+	if errors.Is(err, fs.ErrNotExist) {
+		t, ok := builtinThemes[name]
+		if !ok {
+			return mapTheme{}, fmt.Errorf("theme '%s': %w", name, fs.ErrNotExist)
+		}
+		t.Name = name
+		return t, nil
+	}
+	if err != nil {
+		return mapTheme{}, err
+	}
+
+	base := probe.Base
+	if base == "" {
+		base = defaultTheme
+		if _, ok := builtinThemes[name]; ok {
+			// A file named like a built in theme changes that theme
+			base = name
+		}
+	}
+	b, ok := builtinThemes[base]
+	if !ok {
+		return mapTheme{}, fmt.Errorf("theme '%s' has unknown base '%s': %w", name, base, fs.ErrNotExist)
+	}
+
+	// Decoding over a copy of the base keeps everything the file leaves out
+	t := b.clone()
+	err = readMapData(themesDir, name, &t)
+	if err != nil {
+		return mapTheme{}, err
+	}
+	t.Name = name
+
+	err = t.validate()
+	if err != nil {
+		return mapTheme{}, fmt.Errorf("%w '%s': %s", errMapDecode, name, err.Error())
+	}
+	return t, nil
+}
+
+// validate checks the values of a theme file that are read rather than passed on to the svg as they are
+func (t mapTheme) validate() error {
+	_, err := parseGradient(t.Focus)
+	if err != nil {
+		return fmt.Errorf("focus gradient: %w", err)
+	}
+	_, err = parseGradient(t.Heat)
+	if err != nil {
+		return fmt.Errorf("heat gradient: %w", err)
+	}
+	return nil
+}
+
+// clone copies t so that decoding into it leaves the maps of the original alone
+func (t mapTheme) clone() mapTheme {
+	jumps := make(map[string]themeLine, len(t.Jumps))
+	for k, v := range t.Jumps {
+		jumps[k] = v
+	}
+	t.Jumps = jumps
+	t.Status = copyStrings(t.Status)
+	t.Security = copyStrings(t.Security)
+	t.SecurityStatus = copyStrings(t.SecurityStatus)
+	t.Focus = append([]string(nil), t.Focus...)
+	t.Heat = append([]string(nil), t.Heat...)
+	t.Timers = copyStrings(t.Timers)
+	t.POI = copyStrings(t.POI)
+	return t
+}
+
+func copyStrings(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// jump returns the line style of a connection type, falling back to plain gates
+func (t mapTheme) jump(kind string) themeLine {
+	if l, ok := t.Jumps[kind]; ok {
+		return l
+	}
+	return t.Jumps[jumpGate]
+}
+
+func (l themeLine) style() string {
+	width := l.Width
+	if width == 0 {
+		width = 1
+	}
+	out := fmt.Sprintf("stroke:%s;stroke-width:%gpx", l.Color, width)
+	if l.Dash != "" {
+		out += ";stroke-dasharray:" + l.Dash
+	}
+	return out
+}
+
+// text returns the style of a text in the theme font with the given colour, size and extra style
+func (t mapTheme) text(color string, size int32, extra string) string {
+	out := fmt.Sprintf("font-size:%dpx;fill:%s", size, color)
+	if t.Font != "" {
+		out += ";font-family:" + t.Font
+	}
+	if extra != "" {
+		out += ";" + extra
+	}
+	return out
+}
+
+// Themes lists the names of the built in themes and the theme files
+func Themes() []string {
+	seen := make(map[string]bool)
+	for name := range builtinThemes {
+		seen[name] = true
+	}
+	files, err := listMapFiles(themesDir)
+	if err != nil {
+		log.Printf("WARN: failed to list themes: %v", err)
+	}
+	for _, name := range files {
+		seen[name] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (em *EveMapper) listThemes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, Themes())
+}
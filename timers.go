@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/go-chi/chi"
+)
+
+const (
+	timersFile = "./timers.json"
+
+	timerArmor = "armor"
+	timerHull  = "hull"
+
+	// How soon a timer comes out, its badge is coloured by the theme entry of that name
+	timerLater = "later"
+	timerDay   = "day"
+	timerHours = "hours"
+	timerHour  = "hour"
+	timerOut   = "out"
+	// timerText is the theme entry for the text on the badges
+	timerText = "text"
+
+	// timerGrace keeps a timer on the board for a while after it came out so that the fight can still be found
+	timerGrace = 30 * time.Minute
+)
+
+type (
+	// structureTimer is a reinforcement timer of a structure, Exits is when it comes out
+	structureTimer struct {
+		ID        string    `json:"id"`
+		System    int32     `json:"system"`
+		Structure string    `json:"structure"`
+		Type      string    `json:"type,omitempty"`
+		Timer     string    `json:"timer"`
+		Exits     time.Time `json:"exits"`
+		Owner     string    `json:"owner,omitempty"`
+		Defender  string    `json:"defender,omitempty"`
+	}
+
+	// timerStore keeps the timers in memory and writes them to its file on every change.
+	// Timers are dropped once they are more than timerGrace past their exit time.
+	timerStore struct {
+		fileStore
+		mu     sync.Mutex
+		timers []structureTimer
+	}
+)
+
+// loadTimers reads the timer store at p, a missing file is an empty store
+func loadTimers(p string) (*timerStore, error) {
+	ts := &timerStore{fileStore: fileStore{path: p}}
+	err := ts.load(&ts.timers)
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// save writes timers to disk and only then makes them the contents of the store, so a failed write changes
+// nothing. The caller must hold the lock.
+func (ts *timerStore) save(timers []structureTimer) error {
+	err := ts.fileStore.save(timers)
+	if err != nil {
+		return err
+	}
+	ts.timers = timers
+	return nil
+}
+
+// expired tells whether a timer is more than timerGrace past its exit time
+func (t structureTimer) expired(now time.Time) bool {
+	return now.Sub(t.Exits) > timerGrace
+}
+
+// prune drops the expired timers, the caller must hold the lock
+func (ts *timerStore) prune(now time.Time) {
+	var kept []structureTimer
+	for _, t := range ts.timers {
+		if !t.expired(now) {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(ts.timers) {
+		return
+	}
+
+	err := ts.save(kept)
+	if err != nil {
+		log.Printf("WARN: failed to save pruned timers: %v", err)
+	}
+}
+
+// List returns the running timers of system, or of all systems when it is zero, soonest first
+func (ts *timerStore) List(system int32) []structureTimer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := time.Now()
+	ts.prune(now)
+
+	var found []structureTimer
+	for _, t := range ts.timers {
+		if t.expired(now) {
+			// Only left when pruning failed to save
+			continue
+		}
+		if system == 0 || t.System == system {
+			found = append(found, t)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Exits.Before(found[j].Exits) })
+	return found
+}
+
+// BySystem returns the running timers grouped by system, soonest first
+func (ts *timerStore) BySystem() map[int32][]structureTimer {
+	bySystem := make(map[int32][]structureTimer)
+	for _, t := range ts.List(0) {
+		bySystem[t.System] = append(bySystem[t.System], t)
+	}
+	return bySystem
+}
+
+func (ts *timerStore) Add(t structureTimer) (structureTimer, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t.ID = newRecordID(time.Now())
+	t.Exits = t.Exits.UTC()
+
+	timers := append(append([]structureTimer{}, ts.timers...), t)
+	return t, ts.save(timers)
+}
+
+func (ts *timerStore) Delete(id string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for i, t := range ts.timers {
+		if t.ID == id {
+			timers := append(append([]structureTimer{}, ts.timers[:i]...), ts.timers[i+1:]...)
+			return ts.save(timers)
+		}
+	}
+	return fmt.Errorf("timer '%s': %w", id, fs.ErrNotExist)
+}
+
+func (t structureTimer) String() string {
+	out := fmt.Sprintf("%s %s", t.Structure, t.Timer)
+	if t.Type != "" {
+		out = fmt.Sprintf("%s (%s) %s", t.Structure, t.Type, t.Timer)
+	}
+	if t.Owner != "" {
+		out += ", owner " + t.Owner
+	}
+	if t.Defender != "" {
+		out += ", defended by " + t.Defender
+	}
+	return fmt.Sprintf("%s, exits %s", out, t.Exits.Format("2006-01-02 15:04"))
+}
+
+// countdown formats the time left as days and hours, or hours and minutes on the last day
+func countdown(left time.Duration) string {
+	if left <= 0 {
+		return "out"
+	}
+	left = left.Round(time.Minute)
+	h := int(left / time.Hour)
+	m := int(left % time.Hour / time.Minute)
+	if h >= 24 {
+		return fmt.Sprintf("%dd%02dh", h/24, h%24)
+	}
+	return fmt.Sprintf("%d:%02d", h, m)
+}
+
+// defaultTimerColors go from green to red as a timer gets closer, used for what a theme leaves out
+var defaultTimerColors = map[string]string{
+	timerLater: "rgb(60,170,60)",
+	timerDay:   "rgb(220,200,0)",
+	timerHours: "rgb(240,130,0)",
+	timerHour:  "rgb(220,30,30)",
+	timerOut:   "rgb(160,0,160)",
+	timerText:  "rgb(255,255,255)",
+}
+
+// timerUrgencies are the badge colours of a theme from the latest timers to those that are out
+var timerUrgencies = []string{timerLater, timerDay, timerHours, timerHour, timerOut}
+
+// timerUrgency tells how soon a timer with the given time left comes out
+func timerUrgency(left time.Duration) string {
+	switch {
+	case left <= 0:
+		return timerOut
+	case left <= time.Hour:
+		return timerHour
+	case left <= 6*time.Hour:
+		return timerHours
+	case left <= 24*time.Hour:
+		return timerDay
+	}
+	return timerLater
+}
+
+func (t mapTheme) timerColor(entry string) string {
+	if c, ok := t.Timers[entry]; ok && c != "" {
+		return c
+	}
+	return defaultTimerColors[entry]
+}
+
+func (t mapTheme) timerStyle(urgency string) string {
+	return fmt.Sprintf("fill:%s;stroke:%s;stroke-width:0.5px", t.timerColor(urgency), t.Border)
+}
+
+// drawTimerBadge puts a countdown of the soonest timer of a system under its bottom left corner,
+// hovering it shows all of them
+func drawTimerBadge(canvas *svg.SVG, s spyglassSystem, st spyglassStyle, timers []structureTimer, now time.Time, p painter) {
+	if len(timers) == 0 {
+		return
+	}
+
+	lines := make([]string, 0, len(timers))
+	for _, t := range timers {
+		lines = append(lines, t.String())
+	}
+
+	left := timers[0].Exits.Sub(now)
+	text := countdown(left)
+	if len(timers) > 1 {
+		text = fmt.Sprintf("%s +%d", text, len(timers)-1)
+	}
+
+	x, y := int(s.X), int(s.Y+st.Height)+6
+	canvas.Group(`class="timers"`)
+	canvas.Title(strings.Join(lines, "\n"))
+	urgency := timerUrgency(left)
+	canvas.Roundrect(x, y-6, len(text)*5+6, 10, 3, 3, p.attrs("timer-badge timer-badge--"+urgency, p.theme.timerStyle(urgency))...)
+	canvas.Text(x+3, y+2, text, p.text("timer-text", p.theme.timerColor(timerText), 8, "", "")...)
+	canvas.Gend()
+}
+
+func (em *EveMapper) listTimers(w http.ResponseWriter, r *http.Request) {
+	system, ok := systemParam(w, r)
+	if !ok {
+		return
+	}
+
+	timers := em.Timers.List(system)
+	if timers == nil {
+		timers = []structureTimer{}
+	}
+	writeJSON(w, 200, timers)
+}
+
+func (em *EveMapper) addTimer(w http.ResponseWriter, r *http.Request) {
+	var t structureTimer
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if t.System == 0 || strings.TrimSpace(t.Structure) == "" || t.Exits.IsZero() {
+		w.WriteHeader(400)
+		w.Write([]byte("a timer needs a system, a structure and an exit time"))
+		return
+	}
+	if t.Timer != timerArmor && t.Timer != timerHull {
+		w.WriteHeader(400)
+		w.Write([]byte(fmt.Sprintf("timer must be '%s' or '%s'", timerArmor, timerHull)))
+		return
+	}
+	if _, ok := em.Systems[t.System]; !ok {
+		w.WriteHeader(400)
+		w.Write([]byte(fmt.Sprintf("system %d is not in New Eden", t.System)))
+		return
+	}
+
+	t, err = em.Timers.Add(t)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, 201, t)
+}
+
+func (em *EveMapper) deleteTimer(w http.ResponseWriter, r *http.Request) {
+	err := em.Timers.Delete(chi.URLParam(r, "timer"))
+	if err != nil {
+		w.WriteHeader(storeErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(204)
+}